@@ -19,7 +19,11 @@ func SlowSearch(out io.Writer) {
 		panic(err)
 	}
 
-	fileContents, err := ioutil.ReadAll(file)
+	slowSearch(file, out)
+}
+
+func slowSearch(in io.Reader, out io.Writer) {
+	fileContents, err := ioutil.ReadAll(in)
 	if err != nil {
 		panic(err)
 	}
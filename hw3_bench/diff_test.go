@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// go test -run Diff -diff.n 10000 -diff.seed 0 -diff.save
+
+var (
+	diffRuns = flag.Int("diff.n", 300, "number of random inputs compared by TestDiffRandom")
+	diffSeed = flag.Int64("diff.seed", 1, "seed of TestDiffRandom, random if 0")
+	diffSave = flag.Bool("diff.save", false, "save divergences found by TestDiffRandom to "+diffDir)
+)
+
+const diffDir = "testdata/diff"
+
+// diffOutputs runs the reference and the optimised search over data. skip
+// is set when the reference panics, its output is undefined then.
+func diffOutputs(data string) (slow, fast string, skip bool) {
+	slowOut := new(bytes.Buffer)
+	func() {
+		defer func() {
+			if recover() != nil {
+				skip = true
+			}
+		}()
+		slowSearch(strings.NewReader(data), slowOut)
+	}()
+	if skip {
+		return "", "", true
+	}
+
+	for _, search := range []func(*bytes.Buffer) error{
+		func(out *bytes.Buffer) error {
+			return Search(strings.NewReader(data), out, Options{})
+		},
+		func(out *bytes.Buffer) error {
+			return parallelSearch(strings.NewReader(data), out, Options{Query: legacyQuery, Workers: 3}, 256)
+		},
+	} {
+		fastOut := new(bytes.Buffer)
+		if err := search(fastOut); err != nil {
+			return slowOut.String(), "error: " + err.Error(), false
+		}
+		if fastOut.String() != slowOut.String() {
+			return slowOut.String(), fastOut.String(), false
+		}
+	}
+	return slowOut.String(), slowOut.String(), false
+}
+
+func diverges(lines []string) bool {
+	slow, fast, skip := diffOutputs(strings.Join(lines, "\n"))
+	return !skip && slow != fast
+}
+
+// shrink drops lines one by one while the outputs still differ.
+func shrink(lines []string) []string {
+	for i := 0; i < len(lines); {
+		candidate := append(append([]string{}, lines[:i]...), lines[i+1:]...)
+		if len(candidate) > 0 && diverges(candidate) {
+			lines = candidate
+		} else {
+			i++
+		}
+	}
+	return lines
+}
+
+func saveRegression(t *testing.T, data string) {
+	sum := sha1.Sum([]byte(data))
+	path := filepath.Join(diffDir, hex.EncodeToString(sum[:8])+".jsonl")
+	if err := os.MkdirAll(diffDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("saved as %s", path)
+}
+
+func TestDiffRandom(t *testing.T) {
+	seed := *diffSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("seed %d", seed)
+	r := rand.New(rand.NewSource(seed))
+
+	skipped := 0
+	for run := 0; run < *diffRuns; run++ {
+		lines := make([]string, 1+r.Intn(20))
+		for i := range lines {
+			lines[i] = randomRecord(r)
+		}
+
+		slow, fast, skip := diffOutputs(strings.Join(lines, "\n"))
+		if skip {
+			skipped++
+			continue
+		}
+		if slow != fast {
+			data := strings.Join(shrink(lines), "\n")
+			slow, fast, _ = diffOutputs(data)
+			t.Errorf("outputs differ on\n%s\nslow:\n%s\nfast:\n%s", data, slow, fast)
+			if *diffSave {
+				saveRegression(t, data)
+			}
+			return
+		}
+	}
+	t.Logf("%d of %d inputs skipped, SlowSearch panicked", skipped, *diffRuns)
+}
+
+func TestDiffRegressions(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(diffDir, "*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slow, fast, skip := diffOutputs(string(data))
+		if skip {
+			t.Errorf("%s: SlowSearch panics", path)
+		} else if slow != fast {
+			t.Errorf("%s: outputs differ\nslow:\n%s\nfast:\n%s", path, slow, fast)
+		}
+	}
+}
+
+var diffAgents = []string{
+	"Mozilla/5.0 (Linux; U; Android 4.0.3; ko-kr; LG-L160L Build/IML74K) AppleWebkit/534.30 (KHTML, like Gecko) Version/4.0 Mobile Safari/534.30",
+	"Mozilla/5.0 (compatible; MSIE 10.0; Windows Phone 8.0; Trident/6.0; IEMobile/10.0; ARM; Touch; NOKIA; Lumia 920)",
+	"Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 6.0; Trident/5.0)",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/41.0.2227.0 Safari/537.36",
+	"Opera/9.80 (Android 2.3.3; Linux; Opera Mobi/ADR-1111101157; U; es-ES) Presto/2.9.201 Version/11.50",
+	"Mozilla/5.0 (Windows NT 6.1; WOW64; rv:40.0) Gecko/20100101 Firefox/40.1",
+	"Android",
+	"MSIE",
+	"",
+}
+
+var diffWords = []string{
+	"John", "Smith", "Zoë", "Łukasz", "Иван", "李", "😀", "O'Neil", `"Quoted"`, `back\slash`,
+	"tab\tand\nnewline", "@", "example.com", "Android", "MSIE", " ", " ", "\x7f", "%s", "[at]", "\uFFFD",
+}
+
+var diffFields = []string{"browsers", "email", "name", "company", "country", "job", "phone"}
+
+// randomRecord returns a JSON object resembling a user: fields may be
+// missing, null or of a wrong type, strings are escaped at random.
+func randomRecord(r *rand.Rand) string {
+	keys := append([]string{}, diffFields...)
+	if r.Intn(4) == 0 {
+		keys = append(keys, "extra")
+	}
+	r.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(randomSpace(r) + "{")
+	first := true
+	for _, key := range keys {
+		// SlowSearch panics on matched users without an email, keep them rare
+		// so that most inputs are usable
+		bad := key != "email" || r.Intn(10) == 0
+		if bad && r.Intn(8) == 0 {
+			continue
+		}
+		if !first {
+			buf.WriteString(",")
+		}
+		first = false
+
+		buf.WriteString(randomSpace(r))
+		writeRandomString(r, buf, key, 8)
+		buf.WriteString(randomSpace(r) + ":" + randomSpace(r))
+
+		switch {
+		case bad && r.Intn(12) == 0:
+			buf.WriteString("null")
+		case key == "browsers":
+			writeRandomBrowsers(r, buf)
+		case key == "extra" || key != "name" && key != "email" && r.Intn(6) == 0:
+			writeRandomValue(r, buf, 2)
+		case key == "email":
+			writeRandomString(r, buf, randomText(r)+"@"+randomText(r), 4)
+		default:
+			writeRandomString(r, buf, randomText(r), 4)
+		}
+	}
+	buf.WriteString(randomSpace(r) + "}" + randomSpace(r))
+	return buf.String()
+}
+
+func writeRandomBrowsers(r *rand.Rand, buf *bytes.Buffer) {
+	if r.Intn(10) == 0 {
+		writeRandomValue(r, buf, 1)
+		return
+	}
+
+	buf.WriteString("[")
+	for i, n := 0, r.Intn(6); i < n; i++ {
+		if i > 0 {
+			buf.WriteString("," + randomSpace(r))
+		}
+		switch r.Intn(10) {
+		case 0:
+			writeRandomValue(r, buf, 1)
+		case 1:
+			writeRandomString(r, buf, randomText(r)+diffAgents[r.Intn(len(diffAgents))], 4)
+		default:
+			writeRandomString(r, buf, diffAgents[r.Intn(len(diffAgents))], 20)
+		}
+	}
+	buf.WriteString("]")
+}
+
+func writeRandomValue(r *rand.Rand, buf *bytes.Buffer, depth int) {
+	n := 6
+	if depth <= 0 {
+		n = 4
+	}
+	switch r.Intn(n) {
+	case 0:
+		buf.WriteString([]string{"0", "-1", "3.14", "1e10", "-0.5E-3"}[r.Intn(5)])
+	case 1:
+		buf.WriteString([]string{"true", "false", "null"}[r.Intn(3)])
+	case 2, 3:
+		writeRandomString(r, buf, randomText(r), 4)
+	case 4:
+		buf.WriteString("[")
+		for i, n := 0, r.Intn(3); i < n; i++ {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			writeRandomValue(r, buf, depth-1)
+		}
+		buf.WriteString("]")
+	case 5:
+		buf.WriteString("{")
+		for i, n := 0, r.Intn(3); i < n; i++ {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			writeRandomString(r, buf, randomText(r), 4)
+			buf.WriteString(":")
+			writeRandomValue(r, buf, depth-1)
+		}
+		buf.WriteString("}")
+	}
+}
+
+func randomText(r *rand.Rand) string {
+	words := make([]string, r.Intn(4))
+	for i := range words {
+		words[i] = diffWords[r.Intn(len(diffWords))]
+	}
+	return strings.Join(words, " ")
+}
+
+func randomSpace(r *rand.Rand) string {
+	if r.Intn(5) == 0 {
+		return []string{" ", "  ", "\t", " \t "}[r.Intn(4)]
+	}
+	return ""
+}
+
+// writeRandomString writes s as a JSON string literal escaping one in
+// oneIn runes which could be written as is.
+func writeRandomString(r *rand.Rand, buf *bytes.Buffer, s string, oneIn int) {
+	buf.WriteByte('"')
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			if r.Intn(oneIn) == 0 {
+				writeUnicodeEscape(buf, c)
+			} else {
+				buf.WriteByte('\\')
+				buf.WriteRune(c)
+			}
+		case c < 0x20:
+			if c == '\n' && r.Intn(2) == 0 {
+				buf.WriteString(`\n`)
+			} else {
+				writeUnicodeEscape(buf, c)
+			}
+		case r.Intn(oneIn) == 0:
+			if c == '/' {
+				buf.WriteString(`\/`)
+			} else {
+				writeUnicodeEscape(buf, c)
+			}
+		default:
+			buf.WriteRune(c)
+		}
+	}
+	buf.WriteByte('"')
+}
+
+func writeUnicodeEscape(buf *bytes.Buffer, c rune) {
+	if c > 0xffff {
+		r1, r2 := utf16.EncodeRune(c)
+		fmt.Fprintf(buf, `\u%04x\u%04X`, r1, r2)
+		return
+	}
+	if c == utf8.RuneError {
+		c = 0xd800 // a lone surrogate decodes to the replacement character
+	}
+	fmt.Fprintf(buf, `\u%04x`, c)
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"regexp"
 
 	"github.com/mailru/easyjson/jlexer"
 	"github.com/mailru/easyjson/jwriter"
@@ -15,10 +14,10 @@ type User struct {
 	Browsers []string `json:"browsers"`
 	Email    string   `json:"email"`
 	Name     string   `json:"name"`
-	company  string
-	country  string
-	job      string
-	phone    string
+	Company  string   `json:"company"`
+	Country  string   `json:"country"`
+	Job      string   `json:"job"`
+	Phone    string   `json:"phone"`
 }
 
 func easyjson9e1087fdDecodeGithubComWillsemGolangCourseraHw3BenchGenerate(in *jlexer.Lexer, out *User) {
@@ -67,6 +66,14 @@ func easyjson9e1087fdDecodeGithubComWillsemGolangCourseraHw3BenchGenerate(in *jl
 			out.Email = string(in.String())
 		case "name":
 			out.Name = string(in.String())
+		case "company":
+			out.Company = string(in.String())
+		case "country":
+			out.Country = string(in.String())
+		case "job":
+			out.Job = string(in.String())
+		case "phone":
+			out.Phone = string(in.String())
 		default:
 			in.SkipRecursive()
 		}
@@ -107,6 +114,26 @@ func easyjson9e1087fdEncodeGithubComWillsemGolangCourseraHw3BenchGenerate(out *j
 		out.RawString(prefix)
 		out.String(string(in.Name))
 	}
+	{
+		const prefix string = ",\"company\":"
+		out.RawString(prefix)
+		out.String(string(in.Company))
+	}
+	{
+		const prefix string = ",\"country\":"
+		out.RawString(prefix)
+		out.String(string(in.Country))
+	}
+	{
+		const prefix string = ",\"job\":"
+		out.RawString(prefix)
+		out.String(string(in.Job))
+	}
+	{
+		const prefix string = ",\"phone\":"
+		out.RawString(prefix)
+		out.String(string(in.Phone))
+	}
 	out.RawByte('}')
 }
 
@@ -135,14 +162,31 @@ func (v *User) UnmarshalEasyJSON(l *jlexer.Lexer) {
 }
 
 const (
-	maxUsers = 1000
+	maxUsers    = 1000
+	maxLineSize = 1 << 20
 )
 
-var (
-	androidRegexp, _ = regexp.Compile("Android")
-	msieRegexp, _    = regexp.Compile("MSIE")
-	r, _             = regexp.Compile("@")
-)
+var legacyQuery = MustCompileQuery(defaultQuery)
+
+type Options struct {
+	// Query filters users, nil means the legacy Android and MSIE filter
+	Query *Query
+	// Workers > 1 enables parallel search of line-aligned chunks
+	Workers int
+	// Report switches from listing users to aggregate statistics over them,
+	// either "table" or "json"
+	Report string
+	// Top limits the number of browsers in the report
+	Top int
+	// Format of the user list: "legacy" (default), "jsonl" or "csv"
+	Format string
+	// Columns of the csv format, index, name and email by default
+	Columns []string
+	// Email is how emails are printed: "at" replaces @ with " [at] " and is
+	// the default of the legacy format, "plain" is the default of the others,
+	// "mask" hides the local part and "hash" prints its SHA-256
+	Email string
+}
 
 func FastSearch(out io.Writer) {
 	file, err := os.Open(filePath)
@@ -152,46 +196,113 @@ func FastSearch(out io.Writer) {
 
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	err = Search(file, out, Options{})
+	if err != nil {
+		panic(err)
+	}
+}
 
-	seenBrowsers := make(map[string]bool, maxUsers)
+// Search reads users line by line from in and prints the ones satisfying
+// opts.Query to out.
+func Search(in io.Reader, out io.Writer, opts Options) error {
+	opts, err := prepareOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Workers > 1 {
+		return parallelSearch(in, out, opts, defaultChunkSize)
+	}
 
-	fmt.Fprintln(out, "found users:")
-	user := User{}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	matcher := newLineMatcher(opts)
+
+	if err := writeHeader(out, opts); err != nil {
+		return err
+	}
 	for i := 0; scanner.Scan(); i++ {
-		err := user.UnmarshalJSON(scanner.Bytes())
+		err := matcher.process(out, i, scanner.Bytes())
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("line %d: %s", i, err)
 		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
 
-		isAndroid := false
-		isMSIE := false
+	return writeSummary(out, opts, matcher.state.unique, matcher.stats)
+}
 
-		for _, browser := range user.Browsers {
-			isMatch := false
+func prepareOptions(opts Options) (Options, error) {
+	if opts.Query == nil {
+		opts.Query = legacyQuery
+	}
+	if opts.Report != "" && opts.Report != reportTable && opts.Report != reportJSON {
+		return opts, fmt.Errorf("unknown report format %q", opts.Report)
+	}
+	return opts, checkOutputOptions(opts)
+}
 
-			if ok := androidRegexp.MatchString(browser); ok {
-				isAndroid = true
-				isMatch = true
-			}
+func writeHeader(out io.Writer, opts Options) error {
+	if opts.Report != "" {
+		return nil
+	}
+	return newResultWriter(opts).header(out)
+}
 
-			if ok := msieRegexp.MatchString(browser); ok {
-				isMSIE = true
-				isMatch = true
-			}
+func writeSummary(out io.Writer, opts Options, uniqueBrowsers int, st *stats) error {
+	if opts.Report != "" {
+		return writeReport(out, st.report(uniqueBrowsers, opts.Top), opts.Report)
+	}
 
-			if isMatch {
-				if _, seenBefore := seenBrowsers[browser]; !seenBefore {
-					seenBrowsers[browser] = true
-				}
-			}
-		}
+	return newResultWriter(opts).footer(out, uniqueBrowsers)
+}
+
+type lineMatcher struct {
+	query  *Query
+	needs  fieldSet
+	state  *matchState
+	stats  *stats       // only in report mode
+	result resultWriter // only in list mode
+	view   userView
+	user   User // for lines the scanner gives up on
+}
+
+func newLineMatcher(opts Options) *lineMatcher {
+	browsers := newInternTable()
+	m := &lineMatcher{
+		query: opts.Query,
+		needs: opts.Query.needs,
+		state: opts.Query.newState(browsers),
+	}
+	if opts.Report != "" {
+		m.stats = newStats(browsers)
+		m.needs = m.needs.with(fieldBrowsers, fieldCompany, fieldCountry)
+	} else {
+		m.result = newResultWriter(opts)
+		m.needs = m.needs | m.result.needs()
+	}
+	return m
+}
 
-		if isAndroid && isMSIE {
-			email := r.ReplaceAllString(user.Email, " [at] ")
-			fmt.Fprintln(out, fmt.Sprintf("[%d] %s <%s>", i, user.Name, email))
+// process scans the i-th line and prints it to out if it satisfies the query.
+func (m *lineMatcher) process(out io.Writer, i int, line []byte) error {
+	if !m.view.scan(line, m.needs) {
+		if err := m.view.decode(line, &m.user); err != nil {
+			return err
 		}
 	}
 
-	fmt.Fprintln(out, "\nTotal unique browsers", len(seenBrowsers))
+	if !m.query.match(&m.view, m.state) {
+		return nil
+	}
+
+	if m.stats != nil {
+		m.stats.add(&m.view)
+		return nil
+	}
+
+	return m.result.record(out, i, line, &m.view)
 }
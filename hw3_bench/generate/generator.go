@@ -0,0 +1,215 @@
+package user
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+
+	"github.com/mailru/easyjson/jwriter"
+)
+
+const defaultBrowsers = 4
+
+// Config describes a synthetic user dump. The same config always produces
+// the same output.
+type Config struct {
+	Seed  int64
+	Users int
+	// Browsers per user, 4 like in data/users.txt if 0
+	Browsers int
+	// MatchRatio is the share of users having both an Android and an MSIE
+	// browser, that many users are generated exactly
+	MatchRatio float64
+}
+
+type agentFamily struct {
+	weight   int
+	template string
+	// values substituted in place of the %s verbs of the template
+	params [][]string
+}
+
+var (
+	chromeVersions  = []string{"41.0.2227.0", "49.0.2623.112", "58.0.3029.110", "67.0.3396.99", "79.0.3945.130", "88.0.4324.150", "96.0.4664.45"}
+	firefoxVersions = []string{"10.0.1", "31.0", "40.1", "52.0", "68.0", "78.0", "91.0"}
+	safariBuilds    = []string{"533.17.9", "534.30", "536.26", "537.36", "600.1.4", "601.1.46", "605.1.15"}
+	androidVersions = []string{"1.5", "2.3.3", "4.0.3", "4.4.2", "5.1.1", "6.0.1", "7.0", "8.1.0", "9", "10"}
+	androidDevices  = []string{"Nexus 7 Build/LMY47V", "SM-G900F Build/KOT49H", "LG-L160L Build/IML74K", "HTC One Build/KOT49H", "GT-I9300 Build/JZO54K", "Pixel 2 Build/OPM1.171019.011"}
+	msieVersions    = []string{"6.0", "7.0", "8.0", "9.0", "10.0"}
+	windowsVersions = []string{"5.1", "5.2", "6.0", "6.1", "6.2", "6.3", "10.0"}
+	tridentVersions = []string{"4.0", "5.0", "6.0", "7.0"}
+	macVersions     = []string{"10_6_8", "10_9_5", "10_11_6", "10_13_6", "10_15_7"}
+	iosVersions     = []string{"4_3", "6_0", "9_1", "12_4", "14_6"}
+	botAgents       = []string{"Wget/1.9 cvs-stable (Red Hat modified)", "Lynx/2.8.8dev.3 libwww-FM/2.14 SSL-MM/1.4.1", "msnbot/1.1 (+http://search.msn.com/msnbot.htm)", "W3C_Validator/1.432.2.5", "ELinks/0.12~pre5-4", "Links (2.7; Linux 3.7.9-2-ARCH x86_64; GNU C 4.7.1; text)"}
+)
+
+// agentFamilies roughly follow the shares of browsers on the web of the
+// early 2010s, which data/users.txt is made of.
+var agentFamilies = []agentFamily{
+	{30, "Mozilla/5.0 (Windows NT %s; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%s Safari/537.36", [][]string{windowsVersions, chromeVersions}},
+	{6, "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%s Safari/537.36", [][]string{chromeVersions}},
+	{14, "Mozilla/5.0 (Windows NT %s; WOW64; rv:%s) Gecko/20100101 Firefox/%[2]s", [][]string{windowsVersions, firefoxVersions}},
+	{6, "Mozilla/5.0 (Macintosh; Intel Mac OS X %s) AppleWebKit/%s (KHTML, like Gecko) Version/9.0 Safari/%[2]s", [][]string{macVersions, safariBuilds}},
+	{10, "Mozilla/5.0 (iPhone; CPU iPhone OS %s like Mac OS X) AppleWebKit/%s (KHTML, like Gecko) Version/6.0 Mobile/10A5355d Safari/8536.25", [][]string{iosVersions, safariBuilds}},
+	{10, "Mozilla/5.0 (Linux; Android %s; %s) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%s Mobile Safari/537.36", [][]string{androidVersions, androidDevices, chromeVersions}},
+	{4, "Mozilla/5.0 (Linux; U; Android %s; en-us; %s) AppleWebKit/%s (KHTML, like Gecko) Version/4.0 Mobile Safari/%[3]s", [][]string{androidVersions, androidDevices, safariBuilds}},
+	{2, "Mozilla/5.0 (Android %s; Mobile; rv:%s) Gecko/%[2]s Firefox/%[2]s", [][]string{androidVersions, firefoxVersions}},
+	{8, "Mozilla/4.0 (compatible; MSIE %s; Windows NT %s; Trident/%s)", [][]string{msieVersions, windowsVersions, tridentVersions}},
+	{2, "Mozilla/5.0 (compatible; MSIE %s; Windows Phone OS 7.5; Trident/%s; IEMobile/9.0)", [][]string{msieVersions, tridentVersions}},
+	{4, "Mozilla/5.0 (Windows NT %s; Trident/7.0; rv:11.0) like Gecko", [][]string{windowsVersions}},
+	{3, "Opera/9.80 (Windows NT %s; U; en) Presto/2.12.388 Version/12.%s", [][]string{windowsVersions, {"14", "16", "17"}}},
+	{1, "%s", [][]string{botAgents}},
+}
+
+var (
+	firstNames = []string{"James", "Mary", "John", "Patricia", "Robert", "Jennifer", "Michael", "Linda", "William", "Elizabeth", "David", "Susan", "Richard", "Jessica", "Joseph", "Sarah", "Thomas", "Karen", "Charles", "Lisa", "Sharon", "Melissa", "Jonathan", "Brenda"}
+	lastNames  = []string{"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Rodriguez", "Martinez", "Morris", "Crawford", "Ellis", "Price", "Ramos", "Long", "Hughes", "Reed", "Cook", "Bell"}
+	companies  = []string{"Flashpoint", "Jatri", "Feedbug", "Zooxo", "Muxo", "Topiczoom", "Voonix", "Bluezoom", "Zoombox", "Skinix", "Yodel", "Quatz", "Realbuzz", "Twinder", "Wikizz", "Dabshots"}
+	countries  = []string{"Dominican Republic", "Kenya", "Germany", "Peru", "Russia", "China", "Brazil", "Indonesia", "France", "Philippines", "Sweden", "Portugal", "Poland", "United States", "Japan"}
+	jobs       = []string{"Programmer Analyst #{N}", "Web Developer #{N}", "Internal Auditor", "Office Assistant #{N}", "Automation Specialist #{N}", "Cost Accountant", "Electrical Engineer", "Research Assistant #{N}", "Senior Quality Engineer", "Social Worker"}
+	domains    = []string{"com", "net", "org", "edu", "gov", "info", "biz", "name", "mil"}
+)
+
+// Generate writes cfg.Users users to w as JSON lines. Like data/users.txt
+// the output does not end with a new line.
+func Generate(w io.Writer, cfg Config) error {
+	if cfg.Users < 0 || cfg.MatchRatio < 0 || cfg.MatchRatio > 1 {
+		return fmt.Errorf("invalid config: %d users, match ratio %g", cfg.Users, cfg.MatchRatio)
+	}
+	browsers := cfg.Browsers
+	if browsers == 0 {
+		browsers = defaultBrowsers
+	}
+	if browsers < 2 && cfg.MatchRatio > 0 {
+		return fmt.Errorf("invalid config: a user with %d browsers can't match", browsers)
+	}
+
+	g := &generator{
+		rand:     rand.New(rand.NewSource(cfg.Seed)),
+		browsers: browsers,
+		total:    agentFamiliesWeight(),
+	}
+	matches := int(cfg.MatchRatio*float64(cfg.Users) + 0.5)
+
+	out := bufio.NewWriter(w)
+	jw := jwriter.Writer{}
+	u := User{}
+	for i := 0; i < cfg.Users; i++ {
+		// selection sampling: exactly matches of the users are chosen
+		match := g.rand.Intn(cfg.Users-i) < matches
+		if match {
+			matches--
+		}
+		g.user(&u, match)
+
+		if i > 0 {
+			jw.RawByte('\n')
+		}
+		u.MarshalEasyJSON(&jw)
+		if jw.Size() >= 64*1024 {
+			if _, err := jw.DumpTo(out); err != nil {
+				return err
+			}
+		}
+	}
+	if jw.Error != nil {
+		return jw.Error
+	}
+	if _, err := jw.DumpTo(out); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+type generator struct {
+	rand     *rand.Rand
+	browsers int
+	total    int
+}
+
+func agentFamiliesWeight() int {
+	total := 0
+	for _, f := range agentFamilies {
+		total += f.weight
+	}
+	return total
+}
+
+func (g *generator) pick(items []string) string {
+	return items[g.rand.Intn(len(items))]
+}
+
+func (g *generator) agent() string {
+	n := g.rand.Intn(g.total)
+	for _, f := range agentFamilies {
+		if n < f.weight {
+			args := make([]interface{}, len(f.params))
+			for i, values := range f.params {
+				args[i] = g.pick(values)
+			}
+			return fmt.Sprintf(f.template, args...)
+		}
+		n -= f.weight
+	}
+	panic("unreachable")
+}
+
+// agentWith draws agents until one contains token.
+func (g *generator) agentWith(token string) string {
+	for {
+		if agent := g.agent(); strings.Contains(agent, token) {
+			return agent
+		}
+	}
+}
+
+func hasBoth(browsers []string) bool {
+	android, msie := false, false
+	for _, browser := range browsers {
+		android = android || strings.Contains(browser, "Android")
+		msie = msie || strings.Contains(browser, "MSIE")
+	}
+	return android && msie
+}
+
+func (g *generator) user(u *User, match bool) {
+	u.Browsers = u.Browsers[:0]
+	for i := 0; i < g.browsers; i++ {
+		u.Browsers = append(u.Browsers, g.agent())
+	}
+	if match {
+		if !hasBoth(u.Browsers) {
+			slots := g.rand.Perm(g.browsers)
+			u.Browsers[slots[0]] = g.agentWith("Android")
+			u.Browsers[slots[1]] = g.agentWith("MSIE")
+		}
+	} else {
+		for hasBoth(u.Browsers) {
+			u.Browsers[g.rand.Intn(g.browsers)] = g.agent()
+		}
+	}
+
+	first, last := g.pick(firstNames), g.pick(lastNames)
+	u.Name = first + " " + last
+	u.Company = g.pick(companies)
+	u.Country = g.pick(countries)
+	u.Job = g.pick(jobs)
+
+	switch g.rand.Intn(3) {
+	case 0:
+		u.Email = g.pick(firstNames) + g.pick(lastNames)
+	case 1:
+		u.Email = strings.ToLower(first[:1]) + last
+	default:
+		u.Email = strings.ToLower(first) + "_" + strings.ToLower(last)
+	}
+	u.Email += "@" + g.pick(companies) + "." + g.pick(domains)
+
+	if g.rand.Intn(2) == 0 {
+		u.Phone = fmt.Sprintf("%03d-%02d-%02d", g.rand.Intn(1000), g.rand.Intn(100), g.rand.Intn(100))
+	} else {
+		u.Phone = fmt.Sprintf("%d-%03d-%03d-%02d-%02d", 1+g.rand.Intn(9), g.rand.Intn(1000), g.rand.Intn(1000), g.rand.Intn(100), g.rand.Intn(100))
+	}
+}
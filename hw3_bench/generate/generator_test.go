@@ -0,0 +1,57 @@
+package user
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	cfg := Config{Seed: 42, Users: 500, MatchRatio: 0.2}
+
+	out := new(bytes.Buffer)
+	if err := Generate(out, cfg); err != nil {
+		t.Fatal(err)
+	}
+	again := new(bytes.Buffer)
+	Generate(again, cfg)
+	if out.String() != again.String() {
+		t.Error("the same seed must give the same users")
+	}
+	if bytes.HasSuffix(out.Bytes(), []byte{'\n'}) {
+		t.Error("output must not end with a new line")
+	}
+
+	lines := strings.Split(out.String(), "\n")
+	if len(lines) != cfg.Users {
+		t.Fatalf("got %d users, expected %d", len(lines), cfg.Users)
+	}
+	matches := 0
+	for i, line := range lines {
+		u := User{}
+		if err := u.UnmarshalJSON([]byte(line)); err != nil {
+			t.Fatalf("line %d: %s", i, err)
+		}
+		if len(u.Browsers) != defaultBrowsers || u.Name == "" || !strings.Contains(u.Email, "@") {
+			t.Errorf("line %d: incomplete user %+v", i, u)
+		}
+		if hasBoth(u.Browsers) {
+			matches++
+		}
+	}
+	if matches != 100 {
+		t.Errorf("got %d matching users, expected 100", matches)
+	}
+}
+
+func TestGenerateError(t *testing.T) {
+	for _, cfg := range []Config{
+		{Users: -1},
+		{Users: 10, MatchRatio: 1.5},
+		{Users: 10, Browsers: 1, MatchRatio: 0.5},
+	} {
+		if err := Generate(new(bytes.Buffer), cfg); err == nil {
+			t.Errorf("%+v: expected error", cfg)
+		}
+	}
+}
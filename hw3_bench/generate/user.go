@@ -5,8 +5,8 @@ type User struct {
 	Browsers []string `json:"browsers"`
 	Email    string   `json:"email"`
 	Name     string   `json:"name"`
-	company  string
-	country  string
-	job      string
-	phone    string
+	Company  string   `json:"company"`
+	Country  string   `json:"country"`
+	Job      string   `json:"job"`
+	Phone    string   `json:"phone"`
 }
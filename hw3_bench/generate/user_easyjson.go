@@ -63,6 +63,14 @@ func easyjson9e1087fdDecodeGithubComWillsemGolangCourseraHw3BenchGenerate(in *jl
 			out.Email = string(in.String())
 		case "name":
 			out.Name = string(in.String())
+		case "company":
+			out.Company = string(in.String())
+		case "country":
+			out.Country = string(in.String())
+		case "job":
+			out.Job = string(in.String())
+		case "phone":
+			out.Phone = string(in.String())
 		default:
 			in.SkipRecursive()
 		}
@@ -103,6 +111,26 @@ func easyjson9e1087fdEncodeGithubComWillsemGolangCourseraHw3BenchGenerate(out *j
 		out.RawString(prefix)
 		out.String(string(in.Name))
 	}
+	{
+		const prefix string = ",\"company\":"
+		out.RawString(prefix)
+		out.String(string(in.Company))
+	}
+	{
+		const prefix string = ",\"country\":"
+		out.RawString(prefix)
+		out.String(string(in.Country))
+	}
+	{
+		const prefix string = ",\"job\":"
+		out.RawString(prefix)
+		out.String(string(in.Job))
+	}
+	{
+		const prefix string = ",\"phone\":"
+		out.RawString(prefix)
+		out.String(string(in.Phone))
+	}
 	out.RawByte('}')
 }
 
@@ -2,4 +2,7 @@ module github.com/Willsem/golang-coursera/hw3_bench
 
 go 1.16
 
-require github.com/mailru/easyjson v0.7.7 // indirect
+require (
+	github.com/klauspost/compress v1.15.15
+	github.com/mailru/easyjson v0.7.7
+)
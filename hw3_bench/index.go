@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	indexVersion = 1
+	indexSuffix  = ".idx"
+
+	// postings of emails without exactly one @, which have no domain
+	noDomain = "\x00"
+)
+
+var errStaleIndex = errors.New("index is out of date")
+
+// Index is an inverted index over a user dump: browser tokens and email
+// domains point to the lines they occur in. It remembers the size and the
+// modification time of the source and is considered stale once they change.
+type Index struct {
+	Version int
+	Size    int64
+	ModTime int64
+	// Lines holds the offset of every line and the end of the file
+	Lines    []int64
+	Browsers map[string][]int32
+	Domains  map[string][]int32
+}
+
+func BuildIndex(source string) (*Index, error) {
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{
+		Version:  indexVersion,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UnixNano(),
+		Lines:    make([]int64, 0, maxUsers),
+		Browsers: make(map[string][]int32),
+		Domains:  make(map[string][]int32),
+	}
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	view := userView{}
+	user := User{}
+	needs := fieldSet(0).with(fieldBrowsers, fieldEmail)
+	var offset int64
+	for line := int32(0); ; line++ {
+		raw, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// raw points into the reader buffer, which the next read reuses
+			raw = append([]byte(nil), raw...)
+		}
+		for err == bufio.ErrBufferFull {
+			var more []byte
+			more, err = reader.ReadSlice('\n')
+			raw = append(raw, more...)
+			if len(raw) > maxLineSize {
+				return nil, fmt.Errorf("line %d: %s", line, bufio.ErrTooLong)
+			}
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(raw) == 0 {
+			break
+		}
+
+		idx.Lines = append(idx.Lines, offset)
+		offset += int64(len(raw))
+
+		raw = bytes.TrimSuffix(bytes.TrimSuffix(raw, []byte{'\n'}), []byte{'\r'})
+		if !view.scan(raw, needs) {
+			if err := view.decode(raw, &user); err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err)
+			}
+		}
+
+		for _, browser := range view.browsers {
+			for _, token := range browserTokens(browser) {
+				idx.Browsers[token] = addPosting(idx.Browsers[token], line)
+			}
+		}
+		domain := emailDomain(view.fields[fieldEmail])
+		idx.Domains[domain] = addPosting(idx.Domains[domain], line)
+
+		if err == io.EOF {
+			break
+		}
+	}
+	idx.Lines = append(idx.Lines, offset)
+
+	return idx, nil
+}
+
+func addPosting(postings []int32, line int32) []int32 {
+	if n := len(postings); n > 0 && postings[n-1] == line {
+		return postings
+	}
+	return append(postings, line)
+}
+
+func isTokenByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// browserTokens splits a user agent into maximal runs of letters and digits.
+func browserTokens(browser []byte) []string {
+	tokens := make([]string, 0, 16)
+	for i := 0; i < len(browser); {
+		if !isTokenByte(browser[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(browser) && isTokenByte(browser[i]) {
+			i++
+		}
+		tokens = append(tokens, string(browser[start:i]))
+	}
+	return tokens
+}
+
+func emailDomain(email []byte) string {
+	at := bytes.IndexByte(email, '@')
+	if at < 0 || bytes.IndexByte(email[at+1:], '@') >= 0 {
+		return noDomain
+	}
+	return string(email[at+1:])
+}
+
+func indexPath(source string) string {
+	return source + indexSuffix
+}
+
+func (idx *Index) Save(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	err = gob.NewEncoder(w).Encode(idx)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// LoadIndex reads the index of source and checks that it is still valid.
+func LoadIndex(source string) (*Index, error) {
+	file, err := os.Open(indexPath(source))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	idx := &Index{}
+	err = gob.NewDecoder(bufio.NewReader(file)).Decode(idx)
+	if err != nil {
+		return nil, fmt.Errorf("cant read index: %s", err)
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if idx.Version != indexVersion || idx.Size != info.Size() || idx.ModTime != info.ModTime().UnixNano() {
+		return nil, errStaleIndex
+	}
+	return idx, nil
+}
+
+// OpenIndex loads the index of source, building and saving it first if it
+// does not exist or is stale.
+func OpenIndex(source string) (*Index, error) {
+	idx, err := LoadIndex(source)
+	if err == nil {
+		return idx, nil
+	}
+	if !os.IsNotExist(err) && err != errStaleIndex {
+		return nil, err
+	}
+	if _, statErr := os.Stat(source); statErr != nil {
+		return nil, statErr
+	}
+
+	idx, err = BuildIndex(source)
+	if err != nil {
+		return nil, err
+	}
+	return idx, idx.Save(indexPath(source))
+}
+
+// lineSet is a sorted list of line numbers, or every line if all is set.
+type lineSet struct {
+	all   bool
+	lines []int32
+}
+
+var allLines = lineSet{all: true}
+
+func (a lineSet) and(b lineSet) lineSet {
+	switch {
+	case a.all:
+		return b
+	case b.all:
+		return a
+	}
+	result := make([]int32, 0, min(len(a.lines), len(b.lines)))
+	for i, j := 0, 0; i < len(a.lines) && j < len(b.lines); {
+		switch {
+		case a.lines[i] < b.lines[j]:
+			i++
+		case a.lines[i] > b.lines[j]:
+			j++
+		default:
+			result = append(result, a.lines[i])
+			i++
+			j++
+		}
+	}
+	return lineSet{lines: result}
+}
+
+func (a lineSet) or(b lineSet) lineSet {
+	if a.all || b.all {
+		return allLines
+	}
+	result := make([]int32, 0, len(a.lines)+len(b.lines))
+	i, j := 0, 0
+	for i < len(a.lines) && j < len(b.lines) {
+		switch {
+		case a.lines[i] < b.lines[j]:
+			result = append(result, a.lines[i])
+			i++
+		case a.lines[i] > b.lines[j]:
+			result = append(result, b.lines[j])
+			j++
+		default:
+			result = append(result, a.lines[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a.lines[i:]...)
+	result = append(result, b.lines[j:]...)
+	return lineSet{lines: result}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// unionWhere merges postings of the keys accepted by match.
+func unionWhere(postings map[string][]int32, match func(key string) bool) lineSet {
+	result := lineSet{}
+	for key, lines := range postings {
+		if match(key) {
+			result = result.or(lineSet{lines: lines})
+		}
+	}
+	return result
+}
+
+// candidates returns a superset of the lines which can satisfy the term.
+func (idx *Index) candidates(t *term) lineSet {
+	value := string(t.value)
+
+	switch {
+	case t.field == fieldBrowsers && t.op != opRegexp:
+		// every piece of the value lies inside a single token
+		pieces := strings.FieldsFunc(value, func(r rune) bool {
+			return r < 0x80 && !isTokenByte(byte(r))
+		})
+		if len(pieces) == 0 {
+			return allLines
+		}
+		result := allLines
+		for _, piece := range pieces {
+			result = result.and(unionWhere(idx.Browsers, func(token string) bool {
+				return strings.Contains(token, piece)
+			}))
+		}
+		return result
+
+	case t.field == fieldEmail && t.op == opEquals:
+		domain := emailDomain(t.value)
+		return lineSet{lines: idx.Domains[domain]}.or(lineSet{lines: idx.Domains[noDomain]})
+
+	case t.field == fieldEmail && t.op == opContains && strings.Contains(value, "@"):
+		prefix := value[strings.LastIndexByte(value, '@')+1:]
+		return unionWhere(idx.Domains, func(domain string) bool {
+			return domain == noDomain || strings.HasPrefix(domain, prefix)
+		})
+	}
+
+	return allLines
+}
+
+func (idx *Index) candidatesOf(n node, terms []*term) lineSet {
+	switch n := n.(type) {
+	case andNode:
+		return idx.candidatesOf(n.left, terms).and(idx.candidatesOf(n.right, terms))
+	case orNode:
+		return idx.candidatesOf(n.left, terms).or(idx.candidatesOf(n.right, terms))
+	case termNode:
+		return idx.candidates(terms[n.id])
+	default:
+		return allLines
+	}
+}
+
+// IndexedSearch works like Search over the source file of idx, but reads only
+// the lines which may satisfy the query or contribute to the unique browsers
+// counter. It falls back to a full scan if the query can't use the index.
+func IndexedSearch(file io.ReaderAt, idx *Index, out io.Writer, opts Options) error {
+	opts, err := prepareOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	lines := idx.candidatesOf(opts.Query.root, opts.Query.terms)
+	for _, t := range opts.Query.browserTerms {
+		lines = lines.or(idx.candidates(t))
+	}
+	if lines.all {
+		return Search(io.NewSectionReader(file, 0, idx.Size), out, opts)
+	}
+
+	matcher := newLineMatcher(opts)
+	if err := writeHeader(out, opts); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0, 4096)
+	for _, line := range lines.lines {
+		if int(line)+1 >= len(idx.Lines) {
+			return errStaleIndex
+		}
+		start, end := idx.Lines[line], idx.Lines[line+1]
+		if int64(cap(buf)) < end-start {
+			buf = make([]byte, end-start)
+		}
+		buf = buf[:end-start]
+		if _, err := file.ReadAt(buf, start); err != nil {
+			return err
+		}
+
+		raw := bytes.TrimSuffix(bytes.TrimSuffix(buf, []byte{'\n'}), []byte{'\r'})
+		if err := matcher.process(out, int(line), raw); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+
+	return writeSummary(out, opts, matcher.state.unique, matcher.stats)
+}
+
+func (idx *Index) String() string {
+	return fmt.Sprintf("%d lines, %d browser tokens, %d email domains", len(idx.Lines)-1, len(idx.Browsers), len(idx.Domains))
+}
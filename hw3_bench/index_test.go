@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func copyDataFile(t *testing.T) string {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users.txt")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestIndexedSearch(t *testing.T) {
+	path := copyDataFile(t)
+	idx, err := OpenIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	queries := []string{
+		defaultQuery,
+		`browsers ~ "MSIE 8.0" OR browsers = "Opera/9.80 (Windows NT 6.1; U; es-ES) Presto/2.9.181 Version/12.00"`,
+		`email ~ "@Skib" AND NOT browsers ~ Android`,
+		`email = "JonathanMorris@Muxo.edu"`,
+		`browsers ~ iPhone AND name ~ a`,
+		`browsers =~ "MSIE [0-9]+"`,
+	}
+
+	for _, query := range queries {
+		opts := Options{Query: MustCompileQuery(query)}
+
+		expected := new(bytes.Buffer)
+		file.Seek(0, 0)
+		if err := Search(file, expected, opts); err != nil {
+			t.Fatalf("%s: unexpected error: %s", query, err)
+		}
+
+		got := new(bytes.Buffer)
+		if err := IndexedSearch(file, idx, got, opts); err != nil {
+			t.Fatalf("%s: unexpected error: %s", query, err)
+		}
+		if got.String() != expected.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", query, got, expected)
+		}
+	}
+
+	lines := idx.candidatesOf(legacyQuery.root, legacyQuery.terms)
+	if lines.all || len(lines.lines) >= len(idx.Lines)/2 {
+		t.Errorf("legacy query reads %d lines of %d", len(lines.lines), len(idx.Lines)-1)
+	}
+}
+
+func TestIndexInvalidation(t *testing.T) {
+	path := copyDataFile(t)
+	if _, err := LoadIndex(path); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+
+	idx, err := OpenIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIndex(path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("\n" + `{"browsers":["Android MSIE"],"email":"new@user","name":"New User"}`)
+	file.Close()
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	if _, err := LoadIndex(path); err != errStaleIndex {
+		t.Fatalf("expected stale index, got %v", err)
+	}
+
+	rebuilt, err := OpenIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rebuilt.Lines) != len(idx.Lines)+1 {
+		t.Errorf("got %d lines after rebuild, expected %d", len(rebuilt.Lines)-1, len(idx.Lines))
+	}
+	if _, err := LoadIndex(path); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestIndexLongLines(t *testing.T) {
+	long := `{"browsers":["Android ` + strings.Repeat("x", 100*1024) + `"],"email":"long@user","name":"Long User"}`
+	data := long + "\n" + `{"browsers":["MSIE 8.0"],"email":"short@user","name":"Short User"}` + "\n" + long
+	path := filepath.Join(t.TempDir(), "users.txt")
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := BuildIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Lines) != 4 || idx.Lines[3] != int64(len(data)) {
+		t.Errorf("unexpected line offsets %v", idx.Lines)
+	}
+
+	opts := Options{Query: MustCompileQuery(`browsers ~ Android OR browsers ~ MSIE`)}
+	expected := new(bytes.Buffer)
+	if err := Search(strings.NewReader(data), expected, opts); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	got := new(bytes.Buffer)
+	if err := IndexedSearch(file, idx, got, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != expected.String() {
+		t.Errorf("results not match\nGot:\n%.200s\nExpected:\n%.200s", got, expected)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// openInput opens path, "-" meaning stdin, and transparently decompresses
+// gzip and zstd streams.
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return decompress(ioutil.NopCloser(os.Stdin))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return in, nil
+}
+
+func decompress(in io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReaderSize(in, 64*1024)
+	magic, err := buffered.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return &decompressor{Reader: zr, close: zr.Close, file: in}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return &decompressor{Reader: zr, close: func() error { zr.Close(); return nil }, file: in}, nil
+	default:
+		return &decompressor{Reader: buffered, file: in}, nil
+	}
+}
+
+type decompressor struct {
+	io.Reader
+	close func() error
+	file  io.Closer
+}
+
+func (d *decompressor) Close() error {
+	if d.close != nil {
+		d.close()
+	}
+	return d.file.Close()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	user "github.com/Willsem/golang-coursera/hw3_bench/generate"
+)
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "index":
+			indexCommand(os.Args[2:])
+			return
+		case "generate":
+			generateCommand(os.Args[2:])
+			return
+		}
+	}
+	searchCommand(os.Args[1:])
+}
+
+func searchCommand(args []string) {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	query := flags.String("query", defaultQuery, "filter over user fields, e.g. 'name ~ Smith AND NOT email =~ \"\\\\.com$\"'")
+	workers := flags.Int("workers", 1, "number of goroutines searching chunks of the file in parallel")
+	report := flags.String("report", "", "print statistics over matched users instead of listing them: table or json")
+	top := flags.Int("top", defaultReportTop, "number of browsers in the report")
+	format := flags.String("format", formatLegacy, "format of the user list: legacy, jsonl or csv")
+	columns := flags.String("columns", strings.Join(defaultColumns, ","), "comma separated csv columns: index, name, email, browsers, company, country, job, phone")
+	email := flags.String("email", "", "how to print emails: at, plain, mask or hash (default at for the legacy format, plain otherwise)")
+	useIndex := flags.Bool("index", false, "answer from the index of the file, (re)building it when missing or out of date")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s [flags] [file|-]\n       %s index [file]\n       %s generate [flags] [file]\n", os.Args[0], os.Args[0], os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	q, err := CompileQuery(*query)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	path := filePath
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+	opts := Options{
+		Query:   q,
+		Workers: *workers,
+		Report:  *report,
+		Top:     *top,
+		Format:  *format,
+		Columns: strings.Split(*columns, ","),
+		Email:   *email,
+	}
+
+	if *useIndex {
+		err = searchIndexed(path, opts)
+	} else {
+		err = search(path, opts)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func search(path string, opts Options) error {
+	in, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return Search(in, os.Stdout, opts)
+}
+
+func searchIndexed(path string, opts Options) error {
+	idx, err := OpenIndex(path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return IndexedSearch(file, idx, os.Stdout, opts)
+}
+
+func indexCommand(args []string) {
+	path := filePath
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	idx, err := BuildIndex(path)
+	if err == nil {
+		err = idx.Save(indexPath(path))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s: %s\n", indexPath(path), idx)
+}
+
+func generateCommand(args []string) {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	users := flags.Int("n", maxUsers, "number of users")
+	seed := flags.Int64("seed", 1, "seed of the random generator, the same seed gives the same users")
+	match := flags.Float64("match", 0.1, "share of users having both an Android and an MSIE browser")
+	browsers := flags.Int("browsers", 4, "browsers per user")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s generate [flags] [file]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	out := os.Stdout
+	if flags.NArg() > 0 {
+		file, err := os.Create(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	err := user.Generate(out, user.Config{Seed: *seed, Users: *users, Browsers: *browsers, MatchRatio: *match})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
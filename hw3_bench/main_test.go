@@ -2,8 +2,16 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"runtime"
+	"strings"
 	"testing"
+
+	user "github.com/Willsem/golang-coursera/hw3_bench/generate"
+	"github.com/klauspost/compress/zstd"
 )
 
 // запускаем перед основными функциями по разу чтобы файл остался в памяти в файловом кеше
@@ -30,6 +38,128 @@ func TestSearch(t *testing.T) {
 	}
 }
 
+func TestParallelSearch(t *testing.T) {
+	fastOut := new(bytes.Buffer)
+	FastSearch(fastOut)
+	fastResult := fastOut.String()
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 3, 8} {
+		for _, chunkSize := range []int{64, 4096, defaultChunkSize} {
+			parallelOut := new(bytes.Buffer)
+			err := parallelSearch(bytes.NewReader(data), parallelOut, Options{Query: legacyQuery, Workers: workers}, chunkSize)
+			if err != nil {
+				t.Fatalf("workers %d, chunk %d: unexpected error: %s", workers, chunkSize, err)
+			}
+			if parallelResult := parallelOut.String(); parallelResult != fastResult {
+				t.Errorf("workers %d, chunk %d: results not match\nGot:\n%v\nExpected:\n%v",
+					workers, chunkSize, parallelResult, fastResult)
+			}
+		}
+	}
+}
+
+func TestParallelSearchError(t *testing.T) {
+	data := strings.Repeat(`{"browsers":["Android MSIE"],"name":"a","email":"a@b"}`+"\n", 100) + "not json\n"
+	err := parallelSearch(strings.NewReader(data), ioutil.Discard, Options{Query: legacyQuery, Workers: 4}, 128)
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+// brokenWriter fails every write after the first n bytes like a closed pipe.
+type brokenWriter struct {
+	n int
+}
+
+var errBrokenPipe = errors.New("broken pipe")
+
+func (w *brokenWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		w.n = 0
+		return 0, errBrokenPipe
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestParallelSearchWriteError(t *testing.T) {
+	data := strings.Repeat(`{"browsers":["Android MSIE"],"name":"a","email":"a@b"}`+"\n", 10000)
+	in := strings.NewReader(data)
+	err := parallelSearch(in, &brokenWriter{n: 100}, Options{Query: legacyQuery, Workers: 4}, 128)
+	if err != errBrokenPipe {
+		t.Errorf("expected the write error, got %v", err)
+	}
+	if in.Len() == 0 {
+		t.Error("remaining chunks must not be read after a write error")
+	}
+}
+
+func TestSearchCompressed(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := new(bytes.Buffer)
+	if err := Search(bytes.NewReader(data), expected, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	gzipped := new(bytes.Buffer)
+	gw := gzip.NewWriter(gzipped)
+	gw.Write(data)
+	gw.Close()
+
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zstded := zw.EncodeAll(data, nil)
+	zw.Close()
+
+	cases := map[string][]byte{
+		"plain": data,
+		"gzip":  gzipped.Bytes(),
+		"zstd":  zstded,
+	}
+
+	for name, compressed := range cases {
+		in, err := decompress(ioutil.NopCloser(bytes.NewReader(compressed)))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			continue
+		}
+
+		out := new(bytes.Buffer)
+		err = Search(in, out, Options{Workers: 2})
+		in.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		}
+		if out.String() != expected.String() {
+			t.Errorf("%s: results not match\nGot:\n%v\nExpected:\n%v", name, out, expected)
+		}
+	}
+}
+
+func TestSearchError(t *testing.T) {
+	cases := []string{
+		"{}\nnot json",
+		`{"browsers":["` + strings.Repeat("x", maxLineSize) + `"]}`,
+	}
+
+	for _, data := range cases {
+		if err := Search(strings.NewReader(data), ioutil.Discard, Options{}); err == nil {
+			t.Error("expected error")
+		}
+	}
+}
+
 // -----
 // go test -bench . -benchmem
 
@@ -44,3 +174,33 @@ func BenchmarkFast(b *testing.B) {
 		FastSearch(ioutil.Discard)
 	}
 }
+
+// the bundled file fits into one default chunk, so use smaller ones to keep all workers busy
+func BenchmarkParallel(b *testing.B) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parallelSearch(bytes.NewReader(data), ioutil.Discard, Options{Query: legacyQuery, Workers: runtime.NumCPU()}, 64<<10)
+	}
+}
+
+// go test -bench Scaling
+func BenchmarkScaling(b *testing.B) {
+	for _, users := range []int{1000, 10000, 100000} {
+		data := new(bytes.Buffer)
+		if err := user.Generate(data, user.Config{Seed: 1, Users: users, MatchRatio: 0.05}); err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(fmt.Sprintf("users=%d", users), func(b *testing.B) {
+			b.SetBytes(int64(data.Len()))
+			for i := 0; i < b.N; i++ {
+				Search(bytes.NewReader(data.Bytes()), ioutil.Discard, Options{})
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+// patternSet is an Aho-Corasick automaton which finds all occurrences of a set
+// of substrings in a single pass over the text. Failure links are folded into
+// a dense transition table, so each input byte costs one lookup no matter how
+// many patterns there are. Bytes which occur in no pattern share a column of
+// the table to keep it small enough for the CPU cache.
+type patternSet struct {
+	words   int // uint64 words in a set of found patterns
+	classes [256]int32
+	stride  int // number of byte classes
+	// state*stride + class -> next state*stride<<1, the lowest bit tells
+	// whether any pattern ends in the next state
+	delta []int32
+	out   []uint64 // state*words -> patterns ending in the state
+}
+
+func newPatternSet(patterns [][]byte) *patternSet {
+	p := &patternSet{words: (len(patterns) + 63) / 64, stride: 1}
+	for _, pattern := range patterns {
+		for _, c := range pattern {
+			if p.classes[c] == 0 {
+				p.classes[c] = int32(p.stride)
+				p.stride++
+			}
+		}
+	}
+	p.addState()
+
+	for i, pattern := range patterns {
+		s := 0
+		for _, c := range pattern {
+			edge := s*p.stride + int(p.classes[c])
+			if p.delta[edge] < 0 {
+				p.delta[edge] = p.addState()
+			}
+			s = int(p.delta[edge])
+		}
+		p.out[s*p.words+i/64] |= 1 << uint(i%64)
+	}
+
+	states := len(p.delta) / p.stride
+	fail := make([]int32, states)
+	queue := make([]int32, 0, states)
+	for c := 0; c < p.stride; c++ {
+		if next := p.delta[c]; next < 0 {
+			p.delta[c] = 0
+		} else {
+			queue = append(queue, next)
+		}
+	}
+	for len(queue) > 0 {
+		s := int(queue[0])
+		queue = queue[1:]
+
+		f := int(fail[s])
+		for w := 0; w < p.words; w++ {
+			p.out[s*p.words+w] |= p.out[f*p.words+w]
+		}
+		for c := 0; c < p.stride; c++ {
+			next := p.delta[s*p.stride+c]
+			if next < 0 {
+				p.delta[s*p.stride+c] = p.delta[f*p.stride+c]
+			} else {
+				fail[next] = p.delta[f*p.stride+c]
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	for i, next := range p.delta {
+		p.delta[i] = next * int32(p.stride) << 1
+		if p.hasOut(int(next)) {
+			p.delta[i] |= 1
+		}
+	}
+
+	return p
+}
+
+func (p *patternSet) addState() int32 {
+	for c := 0; c < p.stride; c++ {
+		p.delta = append(p.delta, -1)
+	}
+	for w := 0; w < p.words; w++ {
+		p.out = append(p.out, 0)
+	}
+	return int32(len(p.delta)/p.stride - 1)
+}
+
+func (p *patternSet) hasOut(s int) bool {
+	for w := 0; w < p.words; w++ {
+		if p.out[s*p.words+w] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// find sets bit i of found if the i-th pattern occurs in text.
+func (p *patternSet) find(text []byte, found []uint64) {
+	for w := range found {
+		found[w] = 0
+	}
+
+	p.collect(0, found)
+	s := 0
+	for _, c := range text {
+		next := int(p.delta[s+int(p.classes[c])])
+		s = next >> 1
+		if next&1 != 0 {
+			p.collect(s/p.stride, found)
+		}
+	}
+}
+
+func (p *patternSet) collect(s int, found []uint64) {
+	for w := 0; w < p.words; w++ {
+		found[w] |= p.out[s*p.words+w]
+	}
+}
+
+func hasPattern(found []uint64, i int) bool {
+	return found[i/64]&(1<<uint(i%64)) != 0
+}
+
+// internTable keeps a single copy of every distinct string it has seen, so
+// that a byte slice can be turned into a string or an id without allocation
+// after the first time.
+type internTable struct {
+	ids   map[string]int32
+	names []string
+}
+
+func newInternTable() *internTable {
+	return &internTable{ids: make(map[string]int32, maxUsers)}
+}
+
+func (t *internTable) intern(b []byte) int32 {
+	if id, ok := t.ids[string(b)]; ok {
+		return id
+	}
+	name := string(b)
+	id := int32(len(t.names))
+	t.ids[name] = id
+	t.names = append(t.names, name)
+	return id
+}
+
+func (t *internTable) name(b []byte) string {
+	return t.names[t.intern(b)]
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestPatternSet(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	randomBytes := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = "abc"[rnd.Intn(3)]
+		}
+		return b
+	}
+
+	for round := 0; round < 200; round++ {
+		patterns := make([][]byte, 1+rnd.Intn(70))
+		for i := range patterns {
+			patterns[i] = randomBytes(rnd.Intn(5))
+		}
+		set := newPatternSet(patterns)
+		found := make([]uint64, (len(patterns)+63)/64)
+
+		text := randomBytes(rnd.Intn(30))
+		set.find(text, found)
+		for i, pattern := range patterns {
+			if expected := bytes.Contains(text, pattern); hasPattern(found, i) != expected {
+				t.Fatalf("pattern %q in %q: got %v, expected %v", pattern, text, !expected, expected)
+			}
+		}
+	}
+}
+
+func TestInternTable(t *testing.T) {
+	table := newInternTable()
+	a := table.intern([]byte("MSIE"))
+	b := table.intern([]byte("Android"))
+	if a == b || table.intern([]byte("MSIE")) != a || table.name([]byte("Android")) != "Android" {
+		t.Errorf("unexpected ids: %d, %d, names %q", a, b, table.names)
+	}
+	if allocs := testing.AllocsPerRun(10, func() { table.intern([]byte("MSIE")) }); allocs != 0 {
+		t.Errorf("got %v allocs for known string, expected 0", allocs)
+	}
+}
+
+var benchAgent = []byte("Mozilla/5.0 (Linux; U; Android 4.0.3; ko-kr; LG-L160L Build/IML74K) AppleWebkit/534.30 (KHTML, like Gecko) Version/4.0 Mobile Safari/534.30")
+
+func benchPatterns(n int) [][]byte {
+	known := []string{"Android", "MSIE", "iPhone", "Windows NT", "Opera", "Firefox", "Chrome", "Safari"}
+	patterns := make([][]byte, n)
+	for i := range patterns {
+		patterns[i] = []byte(fmt.Sprintf("%s/%d", known[i%len(known)], i))
+	}
+	patterns[0] = []byte("Android")
+	return patterns
+}
+
+func BenchmarkPatternSet(b *testing.B) {
+	for _, n := range []int{2, 16, 64} {
+		patterns := benchPatterns(n)
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			set := newPatternSet(patterns)
+			found := make([]uint64, (n+63)/64)
+			for i := 0; i < b.N; i++ {
+				set.find(benchAgent, found)
+			}
+		})
+	}
+}
+
+func BenchmarkPatternContains(b *testing.B) {
+	for _, n := range []int{2, 16, 64} {
+		patterns := benchPatterns(n)
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, pattern := range patterns {
+					bytes.Contains(benchAgent, pattern)
+				}
+			}
+		})
+	}
+}
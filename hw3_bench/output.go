@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mailru/easyjson/jwriter"
+)
+
+const (
+	formatLegacy = "legacy"
+	formatJSONL  = "jsonl"
+	formatCSV    = "csv"
+
+	emailAt    = "at"
+	emailPlain = "plain"
+	emailMask  = "mask"
+	emailHash  = "hash"
+)
+
+var (
+	defaultColumns = []string{"index", "name", "email"}
+
+	columnFields = map[string]field{
+		"browsers": fieldBrowsers,
+		"name":     fieldName,
+		"email":    fieldEmail,
+		"company":  fieldCompany,
+		"country":  fieldCountry,
+		"job":      fieldJob,
+		"phone":    fieldPhone,
+	}
+)
+
+// resultWriter prints matched users. A writer belongs to one goroutine and
+// may reuse its buffers between records.
+type resultWriter interface {
+	header(out io.Writer) error
+	// record prints the i-th user, line is the raw record
+	record(out io.Writer, i int, line []byte, v *userView) error
+	footer(out io.Writer, uniqueBrowsers int) error
+	// needs tells which fields record uses
+	needs() fieldSet
+}
+
+func checkOutputOptions(opts Options) error {
+	switch opts.Format {
+	case "", formatLegacy, formatJSONL:
+	case formatCSV:
+		for _, column := range opts.Columns {
+			if _, ok := columnFields[column]; !ok && column != "index" {
+				return fmt.Errorf("unknown column %q", column)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", opts.Format)
+	}
+
+	switch opts.Email {
+	case "", emailAt, emailPlain, emailMask, emailHash:
+		return nil
+	default:
+		return fmt.Errorf("unknown email mode %q", opts.Email)
+	}
+}
+
+func newResultWriter(opts Options) resultWriter {
+	email := opts.Email
+	switch opts.Format {
+	case formatJSONL:
+		if email == "" {
+			email = emailPlain
+		}
+		return &jsonlWriter{email: email}
+	case formatCSV:
+		if email == "" {
+			email = emailPlain
+		}
+		columns := opts.Columns
+		if len(columns) == 0 {
+			columns = defaultColumns
+		}
+		return &csvWriter{email: email, columns: columns}
+	default:
+		if email == "" {
+			email = emailAt
+		}
+		return &legacyWriter{email: email}
+	}
+}
+
+// appendEmail appends email to dst as the mode requires:
+//
+//	at     john [at] example.com
+//	plain  john@example.com
+//	mask   j***@example.com
+//	hash   hex of SHA-256 of the lowercased address
+func appendEmail(dst, email []byte, mode string) []byte {
+	switch mode {
+	case emailPlain:
+		return append(dst, email...)
+	case emailMask:
+		at := bytes.LastIndexByte(email, '@')
+		if at < 0 {
+			at = len(email)
+		}
+		if at > 0 {
+			dst = append(dst, email[0])
+		}
+		dst = append(dst, "***"...)
+		return append(dst, email[at:]...)
+	case emailHash:
+		sum := sha256.Sum256(bytes.ToLower(email))
+		return append(dst, hex.EncodeToString(sum[:])...)
+	default:
+		for _, c := range email {
+			if c == '@' {
+				dst = append(dst, " [at] "...)
+			} else {
+				dst = append(dst, c)
+			}
+		}
+		return dst
+	}
+}
+
+type legacyWriter struct {
+	email string
+	buf   []byte
+}
+
+func (w *legacyWriter) needs() fieldSet {
+	return fieldSet(0).with(fieldName, fieldEmail)
+}
+
+func (w *legacyWriter) header(out io.Writer) error {
+	_, err := fmt.Fprintln(out, "found users:")
+	return err
+}
+
+func (w *legacyWriter) record(out io.Writer, i int, line []byte, v *userView) error {
+	buf := append(w.buf[:0], '[')
+	buf = strconv.AppendInt(buf, int64(i), 10)
+	buf = append(buf, "] "...)
+	if v.present.has(fieldName) {
+		buf = append(buf, v.fields[fieldName]...)
+	} else {
+		// SlowSearch formats a missing name with %s
+		buf = append(buf, "%!s(<nil>)"...)
+	}
+	buf = append(buf, " <"...)
+	buf = appendEmail(buf, v.fields[fieldEmail], w.email)
+	buf = append(buf, ">\n"...)
+	w.buf = buf
+
+	_, err := out.Write(buf)
+	return err
+}
+
+func (w *legacyWriter) footer(out io.Writer, uniqueBrowsers int) error {
+	_, err := fmt.Fprintln(out, "\nTotal unique browsers", uniqueBrowsers)
+	return err
+}
+
+// jsonlWriter prints every matched user as a full JSON record on its own line.
+type jsonlWriter struct {
+	email string
+	view  userView
+	user  User
+	buf   []byte
+}
+
+func (w *jsonlWriter) needs() fieldSet {
+	return 0
+}
+
+func (w *jsonlWriter) header(out io.Writer) error {
+	return nil
+}
+
+func (w *jsonlWriter) record(out io.Writer, i int, line []byte, v *userView) error {
+	if err := w.view.decode(line, &w.user); err != nil {
+		return err
+	}
+	w.buf = appendEmail(w.buf[:0], []byte(w.user.Email), w.email)
+	w.user.Email = string(w.buf)
+
+	jw := jwriter.Writer{}
+	w.user.MarshalEasyJSON(&jw)
+	jw.RawByte('\n')
+	if jw.Error != nil {
+		return jw.Error
+	}
+	_, err := jw.DumpTo(out)
+	return err
+}
+
+func (w *jsonlWriter) footer(out io.Writer, uniqueBrowsers int) error {
+	return nil
+}
+
+// csvWriter prints the chosen columns of matched users, browsers are joined
+// with new lines inside one cell.
+type csvWriter struct {
+	email   string
+	columns []string
+	row     []string
+	buf     []byte
+}
+
+func (w *csvWriter) needs() fieldSet {
+	needs := fieldSet(0)
+	for _, column := range w.columns {
+		if f, ok := columnFields[column]; ok {
+			needs = needs.with(f)
+		}
+	}
+	return needs
+}
+
+func (w *csvWriter) header(out io.Writer) error {
+	cw := csv.NewWriter(out)
+	cw.Write(w.columns)
+	cw.Flush()
+	return cw.Error()
+}
+
+func (w *csvWriter) record(out io.Writer, i int, line []byte, v *userView) error {
+	w.row = w.row[:0]
+	for _, column := range w.columns {
+		switch f := columnFields[column]; {
+		case column == "index":
+			w.row = append(w.row, strconv.Itoa(i))
+		case f == fieldBrowsers:
+			browsers := make([]string, len(v.browsers))
+			for j, browser := range v.browsers {
+				browsers[j] = string(browser)
+			}
+			w.row = append(w.row, strings.Join(browsers, "\n"))
+		case f == fieldEmail:
+			w.buf = appendEmail(w.buf[:0], v.fields[fieldEmail], w.email)
+			w.row = append(w.row, string(w.buf))
+		default:
+			w.row = append(w.row, string(v.fields[f]))
+		}
+	}
+
+	cw := csv.NewWriter(out)
+	cw.Write(w.row)
+	cw.Flush()
+	return cw.Error()
+}
+
+func (w *csvWriter) footer(out io.Writer, uniqueBrowsers int) error {
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const outputData = `{"browsers":["Android MSIE"],"name":"John Smith","email":"john@example.com","company":"Acme","country":"Peru","job":"Driver","phone":"1-2-3"}
+{"browsers":["Safari"],"name":"Jane Doe","email":"jane@example.com"}
+{"browsers":["MSIE 7.0","Android 4.4"],"name":"Li \"Lee\", Chen","email":"li@example.org","company":"Tele\u0073"}
+`
+
+func TestAppendEmail(t *testing.T) {
+	cases := []struct {
+		email, mode, expected string
+	}{
+		{"john@example.com", emailAt, "john [at] example.com"},
+		{"john@example.com", emailPlain, "john@example.com"},
+		{"john@example.com", emailMask, "j***@example.com"},
+		{"@example.com", emailMask, "***@example.com"},
+		{"john", emailMask, "j***"},
+		{"John@Example.com", emailHash, "855f96e983f1f8e8be944692b6f719fd54329826cb62e98015efee8e2e071dd4"},
+		{"john@example.com", emailHash, "855f96e983f1f8e8be944692b6f719fd54329826cb62e98015efee8e2e071dd4"},
+	}
+
+	for _, c := range cases {
+		if got := string(appendEmail(nil, []byte(c.email), c.mode)); got != c.expected {
+			t.Errorf("%s %s:\n\tgot: %s\n\texpected: %s", c.mode, c.email, got, c.expected)
+		}
+	}
+}
+
+func TestSearchFormats(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			"legacy",
+			Options{},
+			"found users:\n[0] John Smith <john [at] example.com>\n[2] Li \"Lee\", Chen <li [at] example.org>\n\nTotal unique browsers 3\n",
+		},
+		{
+			"legacy masked",
+			Options{Email: emailMask},
+			"found users:\n[0] John Smith <j***@example.com>\n[2] Li \"Lee\", Chen <l***@example.org>\n\nTotal unique browsers 3\n",
+		},
+		{
+			"jsonl",
+			Options{Format: formatJSONL},
+			`{"browsers":["Android MSIE"],"email":"john@example.com","name":"John Smith","company":"Acme","country":"Peru","job":"Driver","phone":"1-2-3"}` + "\n" +
+				`{"browsers":["MSIE 7.0","Android 4.4"],"email":"li@example.org","name":"Li \"Lee\", Chen","company":"Teles","country":"","job":"","phone":""}` + "\n",
+		},
+		{
+			"csv",
+			Options{Format: formatCSV},
+			"index,name,email\n0,John Smith,john@example.com\n2,\"Li \"\"Lee\"\", Chen\",li@example.org\n",
+		},
+		{
+			"csv columns",
+			Options{Format: formatCSV, Columns: []string{"company", "browsers", "email"}, Email: emailAt},
+			"company,browsers,email\nAcme,Android MSIE,john [at] example.com\nTeles,\"MSIE 7.0\nAndroid 4.4\",li [at] example.org\n",
+		},
+	}
+
+	for _, c := range cases {
+		for _, workers := range []int{1, 2} {
+			c.opts.Workers = workers
+			out := new(bytes.Buffer)
+			if err := Search(strings.NewReader(outputData), out, c.opts); err != nil {
+				t.Errorf("%s: unexpected error: %s", c.name, err)
+				continue
+			}
+			if out.String() != c.expected {
+				t.Errorf("%s, %d workers:\n\tgot: %q\n\texpected: %q", c.name, workers, out, c.expected)
+			}
+		}
+	}
+}
+
+func TestSearchMissingFields(t *testing.T) {
+	data := `{"browsers":["Android MSIE"],"email":"a@b"}
+{"browsers":["Android MSIE"],"name":null,"email":"c@d"}
+{"browsers":["Android MSIE"],"name":"","email":"e@f"}
+{"browsers":["Android MSIE",1],"name":"N","company":1,"email":"g@h"}
+`
+	cases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			"legacy",
+			Options{},
+			"found users:\n[0] %!s(<nil>) <a [at] b>\n[1] %!s(<nil>) <c [at] d>\n[2]  <e [at] f>\n[3] N <g [at] h>\n\nTotal unique browsers 1\n",
+		},
+		{
+			"jsonl",
+			Options{Format: formatJSONL, Query: MustCompileQuery(`name = N`)},
+			`{"browsers":["Android MSIE"],"email":"g@h","name":"N","company":"","country":"","job":"","phone":""}` + "\n",
+		},
+	}
+
+	for _, c := range cases {
+		for _, workers := range []int{1, 2} {
+			c.opts.Workers = workers
+			out := new(bytes.Buffer)
+			if err := Search(strings.NewReader(data), out, c.opts); err != nil {
+				t.Errorf("%s: unexpected error: %s", c.name, err)
+				continue
+			}
+			if out.String() != c.expected {
+				t.Errorf("%s, %d workers:\n\tgot: %q\n\texpected: %q", c.name, workers, out, c.expected)
+			}
+		}
+	}
+}
+
+func TestSearchCSVParses(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := new(bytes.Buffer)
+	if err := Search(bytes.NewReader(data), list, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	opts := Options{Format: formatCSV, Columns: []string{"index", "browsers", "phone"}}
+	if err := Search(bytes.NewReader(data), out, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the legacy list has a header and a footer of three lines
+	if users := strings.Count(list.String(), "\n") - 3; len(records)-1 != users {
+		t.Errorf("got %d csv records, expected %d", len(records)-1, users)
+	}
+}
+
+func TestSearchFormatError(t *testing.T) {
+	cases := []Options{
+		{Format: "xml"},
+		{Email: "rot13"},
+		{Format: formatCSV, Columns: []string{"name", "age"}},
+	}
+
+	for _, opts := range cases {
+		if err := Search(strings.NewReader(outputData), ioutil.Discard, opts); err == nil {
+			t.Errorf("%+v: expected error", opts)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+const defaultChunkSize = 4 << 20
+
+type chunk struct {
+	first int // index of the first line in data
+	data  []byte
+	done  chan chunkResult
+}
+
+type chunkResult struct {
+	out   []byte
+	seen  []string
+	stats *stats
+	err   error
+}
+
+// parallelSearch splits in into line-aligned chunks which are searched by
+// workers concurrently. Matches are printed in the original line order.
+func parallelSearch(in io.Reader, out io.Writer, opts Options, chunkSize int) error {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan chunk)
+	// pending keeps chunks in input order and bounds the number in flight
+	pending := make(chan chunk, 2*workers)
+	quit := make(chan struct{})
+	defer close(quit)
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for c := range jobs {
+				c.done <- searchChunk(c, opts)
+			}
+		}()
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		readErr <- splitChunks(in, chunkSize, func(c chunk) bool {
+			select {
+			case pending <- c:
+			case <-quit:
+				return false
+			}
+			jobs <- c
+			return true
+		})
+	}()
+
+	seenBrowsers := make(map[string]bool, maxUsers)
+	var st *stats
+	if opts.Report != "" {
+		st = newStats(newInternTable())
+	}
+	if err := writeHeader(out, opts); err != nil {
+		return err
+	}
+
+	for c := range pending {
+		res := <-c.done
+		if res.err != nil {
+			return res.err
+		}
+		if _, err := out.Write(res.out); err != nil {
+			return err
+		}
+		for _, browser := range res.seen {
+			seenBrowsers[browser] = true
+		}
+		if st != nil {
+			st.merge(res.stats)
+		}
+	}
+	if err := <-readErr; err != nil {
+		return err
+	}
+
+	return writeSummary(out, opts, len(seenBrowsers), st)
+}
+
+// splitChunks reads in by blocks of about size bytes cut at line boundaries
+// and passes them to emit until it returns false.
+func splitChunks(in io.Reader, size int, emit func(chunk) bool) error {
+	var carry []byte
+	line := 0
+	for {
+		buf := make([]byte, len(carry)+size)
+		copy(buf, carry)
+		n, err := io.ReadFull(in, buf[len(carry):])
+		buf = buf[:len(carry)+n]
+
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return err
+		}
+
+		data := buf
+		if !eof {
+			cut := bytes.LastIndexByte(buf, '\n')
+			if cut < 0 {
+				// the line is longer than a chunk, keep reading it
+				carry = buf
+				continue
+			}
+			data, carry = buf[:cut+1], buf[cut+1:]
+		}
+
+		if len(data) > 0 {
+			if !emit(chunk{first: line, data: data, done: make(chan chunkResult, 1)}) {
+				return nil
+			}
+			line += bytes.Count(data, []byte{'\n'})
+		}
+
+		if eof {
+			return nil
+		}
+	}
+}
+
+func searchChunk(c chunk, opts Options) chunkResult {
+	out := bytes.NewBuffer(make([]byte, 0, 4096))
+	matcher := newLineMatcher(opts)
+
+	data := c.data
+	for i := c.first; len(data) > 0; i++ {
+		line := data
+		if end := bytes.IndexByte(data, '\n'); end >= 0 {
+			line, data = data[:end], data[end+1:]
+		} else {
+			data = nil
+		}
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+
+		err := matcher.process(out, i, line)
+		if err != nil {
+			return chunkResult{err: fmt.Errorf("line %d: %s", i, err)}
+		}
+	}
+
+	return chunkResult{out: out.Bytes(), seen: matcher.state.seenBrowsers(), stats: matcher.stats}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Query is a compiled filter over user records.
+//
+// Grammar:
+//
+//	expr  = and { "OR" and }
+//	and   = unary { "AND" unary }
+//	unary = "NOT" unary | "(" expr ")" | field op value
+//	op    = "~" (substring) | "=~" (regexp) | "=" (equals)
+//
+// A browsers predicate holds when any of the user's browsers satisfies it.
+// Values are bare words or Go string literals, either interpreted or raw.
+type Query struct {
+	src   string
+	root  node
+	terms []*term
+	needs fieldSet
+
+	browserTerms []*term
+	fieldTerms   []*term
+	// substrings of browsers terms, searched for in one pass
+	patterns *patternSet
+	npattern int
+}
+
+const defaultQuery = `browsers ~ "Android" AND browsers ~ "MSIE"`
+
+// With fewer substrings to look for in browsers, separate vectorised
+// bytes.Contains calls beat a single pass of the automaton.
+const minPatternSet = 12
+
+type field int
+
+const (
+	fieldBrowsers field = iota
+	fieldEmail
+	fieldName
+	fieldCompany
+	fieldCountry
+	fieldJob
+	fieldPhone
+
+	fieldCount
+)
+
+type fieldSet uint
+
+const allFields = fieldSet(1)<<fieldCount - 1
+
+func (s fieldSet) has(f field) bool {
+	return s&(1<<f) != 0
+}
+
+func (s fieldSet) with(fields ...field) fieldSet {
+	for _, f := range fields {
+		s |= 1 << f
+	}
+	return s
+}
+
+func (s fieldSet) without(f field) fieldSet {
+	return s &^ (1 << f)
+}
+
+var fieldNames = map[string]field{
+	"browsers": fieldBrowsers,
+	"email":    fieldEmail,
+	"name":     fieldName,
+	"company":  fieldCompany,
+	"country":  fieldCountry,
+	"job":      fieldJob,
+	"phone":    fieldPhone,
+}
+
+type operator int
+
+const (
+	opContains operator = iota
+	opRegexp
+	opEquals
+)
+
+type term struct {
+	id      int
+	field   field
+	op      operator
+	value   []byte
+	re      *regexp.Regexp
+	pattern int // index in Query.patterns, -1 if none
+}
+
+func (t *term) matchBytes(b []byte) bool {
+	switch t.op {
+	case opContains:
+		return bytes.Contains(b, t.value)
+	case opRegexp:
+		return t.re.Match(b)
+	default:
+		return bytes.Equal(b, t.value)
+	}
+}
+
+type node interface {
+	eval(hits []bool) bool
+}
+
+type andNode struct{ left, right node }
+
+type orNode struct{ left, right node }
+
+type notNode struct{ operand node }
+
+type termNode struct{ id int }
+
+func (n andNode) eval(hits []bool) bool  { return n.left.eval(hits) && n.right.eval(hits) }
+func (n orNode) eval(hits []bool) bool   { return n.left.eval(hits) || n.right.eval(hits) }
+func (n notNode) eval(hits []bool) bool  { return !n.operand.eval(hits) }
+func (n termNode) eval(hits []bool) bool { return hits[n.id] }
+
+func MustCompileQuery(src string) *Query {
+	q, err := CompileQuery(src)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func CompileQuery(src string) (*Query, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser{tokens: tokens, query: &Query{src: src}}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("query: unexpected %q at offset %d", p.peek().text, p.peek().offset)
+	}
+
+	q := p.query
+	q.root = root
+
+	patterns := make([][]byte, 0, len(q.terms))
+	for _, t := range q.terms {
+		t.pattern = -1
+		if t.field != fieldBrowsers {
+			q.fieldTerms = append(q.fieldTerms, t)
+			continue
+		}
+		q.browserTerms = append(q.browserTerms, t)
+		if t.op == opContains {
+			t.pattern = len(patterns)
+			patterns = append(patterns, t.value)
+		}
+	}
+	if len(patterns) >= minPatternSet {
+		q.patterns = newPatternSet(patterns)
+		q.npattern = len(patterns)
+	} else {
+		for _, t := range q.browserTerms {
+			t.pattern = -1
+		}
+	}
+
+	return q, nil
+}
+
+func (q *Query) String() string {
+	return q.src
+}
+
+// matchState is scratch space of a goroutine evaluating a query.
+type matchState struct {
+	hits  []bool
+	found []uint64
+	// browsers satisfying at least one browsers term
+	browsers *internTable
+	seen     []bool
+	unique   int
+}
+
+func (q *Query) newState(browsers *internTable) *matchState {
+	return &matchState{
+		hits:     make([]bool, len(q.terms)),
+		found:    make([]uint64, (q.npattern+63)/64),
+		browsers: browsers,
+	}
+}
+
+func (st *matchState) see(browser []byte) {
+	id := st.browsers.intern(browser)
+	for int(id) >= len(st.seen) {
+		st.seen = append(st.seen, false)
+	}
+	if !st.seen[id] {
+		st.seen[id] = true
+		st.unique++
+	}
+}
+
+func (st *matchState) seenBrowsers() []string {
+	result := make([]string, 0, st.unique)
+	for id, ok := range st.seen {
+		if ok {
+			result = append(result, st.browsers.names[id])
+		}
+	}
+	return result
+}
+
+// match evaluates the query against v. Every browser satisfying at least one
+// browsers term is marked as seen regardless of the overall result, as the
+// legacy unique counter did.
+func (q *Query) match(v *userView, st *matchState) bool {
+	for i := range st.hits {
+		st.hits[i] = false
+	}
+
+	for _, browser := range v.browsers {
+		if q.patterns != nil {
+			q.patterns.find(browser, st.found)
+		}
+
+		matched := false
+		for _, t := range q.browserTerms {
+			var hit bool
+			if t.pattern >= 0 {
+				hit = hasPattern(st.found, t.pattern)
+			} else {
+				hit = t.matchBytes(browser)
+			}
+			if hit {
+				st.hits[t.id] = true
+				matched = true
+			}
+		}
+		if matched {
+			st.see(browser)
+		}
+	}
+
+	for _, t := range q.fieldTerms {
+		st.hits[t.id] = t.matchBytes(v.fields[t.field])
+	}
+
+	return q.root.eval(st.hits)
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	offset int
+}
+
+func tokenize(src string) ([]token, error) {
+	tokens := make([]token, 0, 8)
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case c == '~':
+			tokens = append(tokens, token{tokenOp, "~", i})
+			i++
+		case c == '=':
+			if i+1 < len(src) && src[i+1] == '~' {
+				tokens = append(tokens, token{tokenOp, "=~", i})
+				i += 2
+			} else {
+				tokens = append(tokens, token{tokenOp, "=", i})
+				i++
+			}
+		case c == '"' || c == '`':
+			end := i + 1
+			for ; end < len(src) && src[end] != c; end++ {
+				if c == '"' && src[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("query: unterminated string at offset %d", i)
+			}
+			value, err := strconv.Unquote(src[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("query: bad string at offset %d: %s", i, err)
+			}
+			tokens = append(tokens, token{tokenString, value, i})
+			i = end + 1
+		default:
+			end := i
+			for end < len(src) && isWordByte(src[end]) {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("query: unexpected %q at offset %d", c, i)
+			}
+			tokens = append(tokens, token{tokenWord, src[i:end], i})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+func isWordByte(c byte) bool {
+	return c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) ||
+		strings.IndexByte("_-.@/:;,+*", c) >= 0
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	query  *Query
+}
+
+func (p *parser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return token{kind: tokenWord, offset: len(p.query.src)}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) keyword(kw string) bool {
+	if t := p.peek(); p.pos < len(p.tokens) && t.kind == tokenWord && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.keyword("NOT") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("query: unexpected end of input")
+	}
+
+	if p.peek().kind == tokenLParen {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.peek().kind != tokenRParen {
+			return nil, fmt.Errorf("query: missing closing parenthesis")
+		}
+		p.pos++
+		return inner, nil
+	}
+
+	return p.parseTerm()
+}
+
+func (p *parser) parseTerm() (node, error) {
+	if p.pos+3 > len(p.tokens) {
+		return nil, fmt.Errorf("query: incomplete predicate at offset %d", p.peek().offset)
+	}
+	name, op, value := p.tokens[p.pos], p.tokens[p.pos+1], p.tokens[p.pos+2]
+
+	f, ok := fieldNames[strings.ToLower(name.text)]
+	if name.kind != tokenWord || !ok {
+		return nil, fmt.Errorf("query: unknown field %q at offset %d", name.text, name.offset)
+	}
+	if op.kind != tokenOp {
+		return nil, fmt.Errorf("query: expected operator at offset %d", op.offset)
+	}
+	if value.kind != tokenWord && value.kind != tokenString {
+		return nil, fmt.Errorf("query: expected value at offset %d", value.offset)
+	}
+	p.pos += 3
+
+	t := &term{
+		id:    len(p.query.terms),
+		field: f,
+		value: []byte(value.text),
+	}
+	switch op.text {
+	case "~":
+		t.op = opContains
+	case "=~":
+		re, err := regexp.Compile(value.text)
+		if err != nil {
+			return nil, fmt.Errorf("query: bad regexp at offset %d: %s", value.offset, err)
+		}
+		t.op, t.re = opRegexp, re
+	default:
+		t.op = opEquals
+	}
+
+	p.query.terms = append(p.query.terms, t)
+	p.query.needs = p.query.needs.with(f)
+	return termNode{t.id}, nil
+}
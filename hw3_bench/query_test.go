@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueryMatch(t *testing.T) {
+	user := User{
+		Browsers: []string{"Mozilla/5.0 (Linux; Android 4.4)", "Opera/9.80 (Windows NT 6.1)"},
+		Email:    "john@example.com",
+		Name:     "John Smith",
+		Country:  "Norway",
+	}
+
+	cases := []struct {
+		query    string
+		expected bool
+		seen     int
+	}{
+		{`browsers ~ Android`, true, 1},
+		{`browsers ~ "Android" AND browsers ~ "MSIE"`, false, 1},
+		{`browsers ~ Android OR browsers ~ MSIE`, true, 1},
+		{`NOT browsers ~ MSIE`, true, 0},
+		{`browsers =~ "^(Opera|Mozilla)/"`, true, 2},
+		{`name = "John Smith" AND email =~ ` + "`\\.com$`", true, 0},
+		{`name = John`, false, 0},
+		{`country = Norway AND NOT company ~ ""`, false, 0},
+		{`(name ~ Jane OR name ~ John) and not email ~ "@gmail"`, true, 0},
+	}
+
+	for _, c := range cases {
+		q, err := CompileQuery(c.query)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.query, err)
+			continue
+		}
+
+		view := userView{}
+		view.fromUser(&user)
+		state := q.newState(newInternTable())
+		got := q.match(&view, state)
+		if got != c.expected {
+			t.Errorf("%s: got %v, expected %v", c.query, got, c.expected)
+		}
+		if state.unique != c.seen {
+			t.Errorf("%s: seen %d browsers, expected %d", c.query, state.unique, c.seen)
+		}
+	}
+}
+
+func TestQueryCompileError(t *testing.T) {
+	cases := []string{
+		``,
+		`browsers`,
+		`browsers ~`,
+		`age ~ 123`,
+		`name ~ "unterminated`,
+		`name =~ "("`,
+		`(name ~ a`,
+		`name ~ a OR`,
+		`name ~ a name ~ b`,
+		`name ! a`,
+	}
+
+	for _, c := range cases {
+		if _, err := CompileQuery(c); err == nil {
+			t.Errorf("%s: expected error", c)
+		}
+	}
+}
+
+func TestQueryPatternSet(t *testing.T) {
+	browsers := []string{"Android", "MSIE", "iPhone", "Opera", "Firefox", "Chrome", "Safari", "Gecko", "Trident", "Presto", "Linux", "Windows"}
+	terms := make([]string, len(browsers))
+	for i, browser := range browsers {
+		terms[i] = "browsers ~ " + browser
+	}
+
+	q := MustCompileQuery("(" + strings.Join(terms, " OR ") + ") AND NOT browsers ~ Chrome")
+	if q.patterns == nil {
+		t.Fatal("expected pattern set")
+	}
+
+	cases := []struct {
+		browsers []string
+		expected bool
+		seen     int
+	}{
+		{[]string{"Mozilla/5.0 (Linux; Android 4.4) Gecko"}, true, 1},
+		{[]string{"Mozilla/5.0 (Windows NT 10.0) Chrome/41.0", "Opera/9.80"}, false, 2},
+		{[]string{"LG-LX550 AU-MIC-LX550/2.0"}, false, 0},
+	}
+
+	for _, c := range cases {
+		view := userView{}
+		view.fromUser(&User{Browsers: c.browsers})
+		state := q.newState(newInternTable())
+		if got := q.match(&view, state); got != c.expected {
+			t.Errorf("%q: got %v, expected %v", c.browsers, got, c.expected)
+		}
+		if state.unique != c.seen {
+			t.Errorf("%q: seen %d browsers, expected %d", c.browsers, state.unique, c.seen)
+		}
+	}
+}
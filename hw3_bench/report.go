@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	reportTable = "table"
+	reportJSON  = "json"
+
+	defaultReportTop = 10
+)
+
+// stats aggregates matched users, every map counts users rather than
+// occurrences.
+type stats struct {
+	agents    *internTable // shared with the matcher
+	names     *internTable
+	parsed    map[string]userAgent
+	users     int
+	browsers  map[string]int
+	os        map[string]int
+	engines   map[string]int
+	countries map[string]int
+	companies map[string]int
+}
+
+type userAgent struct {
+	os, engine string
+}
+
+func newStats(agents *internTable) *stats {
+	return &stats{
+		agents:    agents,
+		names:     newInternTable(),
+		parsed:    make(map[string]userAgent),
+		browsers:  make(map[string]int),
+		os:        make(map[string]int),
+		engines:   make(map[string]int),
+		countries: make(map[string]int),
+		companies: make(map[string]int),
+	}
+}
+
+func (s *stats) add(v *userView) {
+	s.users++
+	s.countries[s.names.name(v.fields[fieldCountry])]++
+	s.companies[s.names.name(v.fields[fieldCompany])]++
+
+	var os, engines []string
+	for i, raw := range v.browsers {
+		if indexOfBytes(v.browsers[:i], raw) >= 0 {
+			continue
+		}
+		browser := s.agents.name(raw)
+		s.browsers[browser]++
+
+		ua, ok := s.parsed[browser]
+		if !ok {
+			ua.os, ua.engine = parseUserAgent(browser)
+			s.parsed[browser] = ua
+		}
+		o, e := ua.os, ua.engine
+		if indexOf(os, o) < 0 {
+			os = append(os, o)
+			s.os[o]++
+		}
+		if indexOf(engines, e) < 0 {
+			engines = append(engines, e)
+			s.engines[e]++
+		}
+	}
+}
+
+func (s *stats) merge(other *stats) {
+	s.users += other.users
+	for _, pair := range [][2]map[string]int{
+		{s.browsers, other.browsers},
+		{s.os, other.os},
+		{s.engines, other.engines},
+		{s.countries, other.countries},
+		{s.companies, other.companies},
+	} {
+		for key, n := range pair[1] {
+			pair[0][key] += n
+		}
+	}
+}
+
+func indexOfBytes(items [][]byte, item []byte) int {
+	for i := range items {
+		if bytes.Equal(items[i], item) {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexOf(items []string, item string) int {
+	for i := range items {
+		if items[i] == item {
+			return i
+		}
+	}
+	return -1
+}
+
+var osSignatures = []struct{ token, name string }{
+	{"Windows Phone", "Windows Phone"},
+	{"Android", "Android"},
+	{"iPhone", "iOS"},
+	{"iPad", "iOS"},
+	{"iPod", "iOS"},
+	{"BlackBerry", "BlackBerry"},
+	{"BB10", "BlackBerry"},
+	{"Symbian", "Symbian"},
+	{"SymbOS", "Symbian"},
+	{"Windows", "Windows"},
+	{"Mac OS X", "macOS"},
+	{"Macintosh", "macOS"},
+	{"CrOS", "Chrome OS"},
+	{"FreeBSD", "FreeBSD"},
+	{"OpenBSD", "OpenBSD"},
+	{"Linux", "Linux"},
+}
+
+var engineSignatures = []struct{ token, name string }{
+	{"Edge/", "EdgeHTML"},
+	{"Trident/", "Trident"},
+	{"MSIE", "Trident"},
+	{"Presto/", "Presto"},
+	{"Chrome/", "Blink"},
+	{"AppleWebKit/", "WebKit"},
+	{"KHTML", "KHTML"},
+	{"Gecko/", "Gecko"},
+	{"rv:", "Gecko"},
+}
+
+// parseUserAgent guesses the operating system and the rendering engine by
+// well-known user agent tokens, the first matching signature wins.
+func parseUserAgent(ua string) (os, engine string) {
+	os, engine = "Other", "Other"
+	for _, sig := range osSignatures {
+		if strings.Contains(ua, sig.token) {
+			os = sig.name
+			break
+		}
+	}
+	for _, sig := range engineSignatures {
+		if strings.Contains(ua, sig.token) {
+			engine = sig.name
+			break
+		}
+	}
+	return
+}
+
+type Count struct {
+	Name  string `json:"name"`
+	Users int    `json:"users"`
+}
+
+type Report struct {
+	Users          int     `json:"users"`
+	UniqueBrowsers int     `json:"unique_browsers"`
+	TopBrowsers    []Count `json:"top_browsers"`
+	OS             []Count `json:"os"`
+	Engines        []Count `json:"engines"`
+	Countries      []Count `json:"countries"`
+	Companies      []Count `json:"companies"`
+}
+
+func (s *stats) report(uniqueBrowsers, top int) Report {
+	if top <= 0 {
+		top = defaultReportTop
+	}
+	return Report{
+		Users:          s.users,
+		UniqueBrowsers: uniqueBrowsers,
+		TopBrowsers:    sortCounts(s.browsers, top),
+		OS:             sortCounts(s.os, 0),
+		Engines:        sortCounts(s.engines, 0),
+		Countries:      sortCounts(s.countries, 0),
+		Companies:      sortCounts(s.companies, 0),
+	}
+}
+
+// sortCounts orders counts by users desc, then by name, and keeps the first
+// limit of them, all if limit is 0.
+func sortCounts(counts map[string]int, limit int) []Count {
+	result := make([]Count, 0, len(counts))
+	for name, users := range counts {
+		result = append(result, Count{name, users})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Users != result[j].Users {
+			return result[i].Users > result[j].Users
+		}
+		return result[i].Name < result[j].Name
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+func writeReport(out io.Writer, report Report, format string) error {
+	switch format {
+	case reportJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case reportTable:
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "Users\t%d\n", report.Users)
+		fmt.Fprintf(w, "Unique browsers\t%d\n", report.UniqueBrowsers)
+		for _, section := range []struct {
+			title  string
+			counts []Count
+		}{
+			{"BROWSER", report.TopBrowsers},
+			{"OS", report.OS},
+			{"ENGINE", report.Engines},
+			{"COUNTRY", report.Countries},
+			{"COMPANY", report.Companies},
+		} {
+			fmt.Fprintf(w, "\n%s\tUSERS\n", section.title)
+			for _, c := range section.counts {
+				fmt.Fprintf(w, "%s\t%d\n", c.Name, c.Users)
+			}
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
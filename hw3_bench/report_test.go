@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	cases := []struct {
+		ua, os, engine string
+	}{
+		{"Mozilla/5.0 (Linux; U; Android 4.0.3; ko-kr; LG-L160L Build/IML74K) AppleWebkit/534.30 (KHTML, like Gecko) Version/4.0 Mobile Safari/534.30", "Android", "KHTML"},
+		{"Mozilla/5.0 (compatible; MSIE 10.0; Windows Phone 8.0; Trident/6.0; IEMobile/10.0; ARM; Touch; NOKIA; Lumia 920)", "Windows Phone", "Trident"},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/41.0.2227.0 Safari/537.36", "Linux", "Blink"},
+		{"Mozilla/5.0 (iPad; CPU OS 6_0 like Mac OS X) AppleWebKit/536.26 (KHTML, like Gecko) Version/6.0 Mobile/10A5355d Safari/8536.25", "iOS", "WebKit"},
+		{"Mozilla/5.0 (Windows NT 6.1; WOW64; rv:40.0) Gecko/20100101 Firefox/40.1", "Windows", "Gecko"},
+		{"Opera/9.80 (Macintosh; Intel Mac OS X 10.6.8; U; fr) Presto/2.9.168 Version/11.52", "macOS", "Presto"},
+		{"LG-LX550 AU-MIC-LX550/2.0 MMP/2.0 Profile/MIDP-2.0 Configuration/CLDC-1.1", "Other", "Other"},
+	}
+
+	for _, c := range cases {
+		os, engine := parseUserAgent(c.ua)
+		if os != c.os || engine != c.engine {
+			t.Errorf("%s:\n\tgot: %s, %s\n\texpected: %s, %s", c.ua, os, engine, c.os, c.engine)
+		}
+	}
+}
+
+func TestSearchReport(t *testing.T) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listOut := new(bytes.Buffer)
+	if err := Search(bytes.NewReader(data), listOut, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	matched := strings.Count(listOut.String(), "\n[")
+
+	reports := make([]Report, 0, 2)
+	for _, workers := range []int{1, 4} {
+		out := new(bytes.Buffer)
+		err := Search(bytes.NewReader(data), out, Options{Workers: workers, Report: reportJSON, Top: 3})
+		if err != nil {
+			t.Fatalf("workers %d: unexpected error: %s", workers, err)
+		}
+
+		var report Report
+		if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+			t.Fatalf("workers %d: cant unpack report: %s", workers, err)
+		}
+		reports = append(reports, report)
+	}
+
+	report := reports[0]
+	if report.Users != matched {
+		t.Errorf("users: got %d, expected %d", report.Users, matched)
+	}
+	if len(report.TopBrowsers) != 3 {
+		t.Errorf("top browsers: got %d, expected 3", len(report.TopBrowsers))
+	}
+	countries := 0
+	for _, c := range report.Countries {
+		countries += c.Users
+	}
+	if countries != matched {
+		t.Errorf("countries: got %d users, expected %d", countries, matched)
+	}
+
+	sequential, _ := json.Marshal(reports[0])
+	parallel, _ := json.Marshal(reports[1])
+	if !bytes.Equal(sequential, parallel) {
+		t.Errorf("parallel report not match\nGot:\n%s\nExpected:\n%s", parallel, sequential)
+	}
+
+	if err := Search(bytes.NewReader(data), ioutil.Discard, Options{Report: "xml"}); err == nil {
+		t.Error("expected error")
+	}
+}
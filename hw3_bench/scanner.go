@@ -0,0 +1,354 @@
+package main
+
+import "github.com/mailru/easyjson/jlexer"
+
+// userView is a record as slices of the raw line, only the fields requested
+// by scan are filled, the rest are left empty.
+type userView struct {
+	browsers [][]byte
+	fields   [fieldCount][]byte
+	// present tells which fields are set, a missing or null name is printed
+	// differently from an empty one by the legacy format
+	present fieldSet
+}
+
+func (v *userView) reset() {
+	v.browsers = v.browsers[:0]
+	for i := range v.fields {
+		v.fields[i] = nil
+	}
+	v.present = 0
+}
+
+// fromUser fills the view from a fully decoded record.
+func (v *userView) fromUser(u *User) {
+	v.reset()
+	for _, browser := range u.Browsers {
+		v.browsers = append(v.browsers, []byte(browser))
+	}
+	v.fields[fieldEmail] = []byte(u.Email)
+	v.fields[fieldName] = []byte(u.Name)
+	v.fields[fieldCompany] = []byte(u.Company)
+	v.fields[fieldCountry] = []byte(u.Country)
+	v.fields[fieldJob] = []byte(u.Job)
+	v.fields[fieldPhone] = []byte(u.Phone)
+	v.present = allFields
+}
+
+func (u *User) field(f field) *string {
+	switch f {
+	case fieldEmail:
+		return &u.Email
+	case fieldName:
+		return &u.Name
+	case fieldCompany:
+		return &u.Company
+	case fieldCountry:
+		return &u.Country
+	case fieldJob:
+		return &u.Job
+	default:
+		return &u.Phone
+	}
+}
+
+// decode fills u and the view from line like SlowSearch sees it: values of
+// a wrong type are ignored instead of failing the whole record, browsers
+// which are not strings are skipped.
+func (v *userView) decode(line []byte, u *User) error {
+	*u = User{Browsers: u.Browsers[:0]}
+	present := fieldSet(0)
+
+	in := jlexer.Lexer{Data: line}
+	if in.IsNull() {
+		in.Skip()
+	} else {
+		in.Delim('{')
+		for !in.IsDelim('}') {
+			key := in.UnsafeFieldName(false)
+			in.WantColon()
+			value := in.Interface()
+			in.WantComma()
+
+			f, known := fieldNames[key]
+			if !known {
+				continue
+			}
+			if f == fieldBrowsers {
+				u.Browsers = u.Browsers[:0]
+				present = present.without(f)
+				list, ok := value.([]interface{})
+				if !ok {
+					continue
+				}
+				for _, item := range list {
+					if browser, ok := item.(string); ok {
+						u.Browsers = append(u.Browsers, browser)
+					}
+				}
+				present = present.with(f)
+				continue
+			}
+
+			s, ok := value.(string)
+			if value != nil && !ok {
+				continue
+			}
+			*u.field(f) = s
+			if ok {
+				present = present.with(f)
+			} else {
+				present = present.without(f)
+			}
+		}
+		in.Delim('}')
+	}
+	in.Consumed()
+	if err := in.Error(); err != nil {
+		return err
+	}
+
+	v.fromUser(u)
+	v.present = present
+	return nil
+}
+
+// scan walks a JSON object in line without allocating and stores the needed
+// fields. It gives up and returns false on anything it does not handle
+// itself: malformed input, escaped keys or needed values, non-string values
+// of known fields. The caller is expected to fall back to full decoding then.
+func (v *userView) scan(line []byte, needs fieldSet) bool {
+	v.reset()
+
+	s := jsonScanner{data: line}
+	s.skipSpace()
+	if !s.consume('{') {
+		return false
+	}
+
+	s.skipSpace()
+	if s.consume('}') {
+		return s.end()
+	}
+
+	for {
+		s.skipSpace()
+		key, escaped, ok := s.string()
+		if !ok || escaped {
+			return false
+		}
+		s.skipSpace()
+		if !s.consume(':') {
+			return false
+		}
+		s.skipSpace()
+
+		f, known := fieldNames[string(key)]
+		switch {
+		case !known || !needs.has(f):
+			if !s.skipValue() {
+				return false
+			}
+		case s.literal("null"):
+			if f == fieldBrowsers {
+				v.browsers = v.browsers[:0]
+			}
+			v.fields[f] = nil
+			v.present = v.present.without(f)
+		case f == fieldBrowsers:
+			if !v.scanBrowsers(&s) {
+				return false
+			}
+			v.present = v.present.with(f)
+		default:
+			value, escaped, ok := s.string()
+			if !ok || escaped {
+				return false
+			}
+			v.fields[f] = value
+			v.present = v.present.with(f)
+		}
+
+		s.skipSpace()
+		if s.consume(',') {
+			continue
+		}
+		if s.consume('}') {
+			return s.end()
+		}
+		return false
+	}
+}
+
+func (v *userView) scanBrowsers(s *jsonScanner) bool {
+	if !s.consume('[') {
+		return false
+	}
+	v.browsers = v.browsers[:0]
+
+	s.skipSpace()
+	if s.consume(']') {
+		return true
+	}
+
+	for {
+		s.skipSpace()
+		browser, escaped, ok := s.string()
+		if !ok || escaped {
+			return false
+		}
+		v.browsers = append(v.browsers, browser)
+
+		s.skipSpace()
+		if s.consume(',') {
+			continue
+		}
+		return s.consume(']')
+	}
+}
+
+type jsonScanner struct {
+	data []byte
+	pos  int
+}
+
+func (s *jsonScanner) skipSpace() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\r', '\n':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (s *jsonScanner) consume(c byte) bool {
+	if s.pos < len(s.data) && s.data[s.pos] == c {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+func (s *jsonScanner) end() bool {
+	s.skipSpace()
+	return s.pos == len(s.data)
+}
+
+func (s *jsonScanner) literal(lit string) bool {
+	if len(s.data)-s.pos >= len(lit) && string(s.data[s.pos:s.pos+len(lit)]) == lit {
+		s.pos += len(lit)
+		return true
+	}
+	return false
+}
+
+// string returns the raw contents of a string literal and whether it has
+// escape sequences which are left as is.
+func (s *jsonScanner) string() (value []byte, escaped, ok bool) {
+	if !s.consume('"') {
+		return nil, false, false
+	}
+	start := s.pos
+	for s.pos < len(s.data) {
+		switch c := s.data[s.pos]; {
+		case c == '"':
+			s.pos++
+			return s.data[start : s.pos-1], escaped, true
+		case c == '\\':
+			escaped = true
+			s.pos += 2
+		case c < 0x20:
+			return nil, false, false
+		default:
+			s.pos++
+		}
+	}
+	return nil, false, false
+}
+
+func (s *jsonScanner) skipValue() bool {
+	if s.pos >= len(s.data) {
+		return false
+	}
+
+	switch c := s.data[s.pos]; {
+	case c == '"':
+		_, _, ok := s.string()
+		return ok
+	case c == '{' || c == '[':
+		return s.skipContainer()
+	case c == '-' || c >= '0' && c <= '9':
+		return s.number()
+	default:
+		return s.literal("true") || s.literal("false") || s.literal("null")
+	}
+}
+
+func (s *jsonScanner) skipContainer() bool {
+	closing := byte(']')
+	if s.consume('{') {
+		closing = '}'
+	} else {
+		s.consume('[')
+	}
+
+	s.skipSpace()
+	if s.consume(closing) {
+		return true
+	}
+
+	for {
+		s.skipSpace()
+		if closing == '}' {
+			if _, _, ok := s.string(); !ok {
+				return false
+			}
+			s.skipSpace()
+			if !s.consume(':') {
+				return false
+			}
+			s.skipSpace()
+		}
+		if !s.skipValue() {
+			return false
+		}
+
+		s.skipSpace()
+		if s.consume(',') {
+			continue
+		}
+		return s.consume(closing)
+	}
+}
+
+// number checks the JSON number grammar.
+func (s *jsonScanner) number() bool {
+	s.consume('-')
+	if s.consume('0') {
+		// no leading zeros
+	} else if !s.digits() {
+		return false
+	}
+	if s.consume('.') && !s.digits() {
+		return false
+	}
+	if s.consume('e') || s.consume('E') {
+		if !s.consume('+') {
+			s.consume('-')
+		}
+		if !s.digits() {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *jsonScanner) digits() bool {
+	start := s.pos
+	for s.pos < len(s.data) && s.data[s.pos] >= '0' && s.data[s.pos] <= '9' {
+		s.pos++
+	}
+	return s.pos > start
+}
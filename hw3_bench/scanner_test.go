@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestUserViewScan(t *testing.T) {
+	all := fieldSet(0).with(fieldBrowsers, fieldEmail, fieldName, fieldCompany, fieldCountry, fieldJob, fieldPhone)
+
+	cases := []struct {
+		line  string
+		needs fieldSet
+		ok    bool
+	}{
+		{`{"browsers":["a","b"],"email":"x@y","name":"N"}`, all, true},
+		{` { "name" : "N" , "browsers" : [ ] } `, all, true},
+		{`{}`, all, true},
+		{`{"name":null,"browsers":null}`, all, true},
+		{`{"extra":{"a":[1,-2.5e+3,true,false,null,"s\"q"]},"name":"N"}`, all, true},
+		{`{"name":"Jo\"hn"}`, all, false},
+		{`{"name":"Jo\"hn"}`, fieldSet(0).with(fieldEmail), true},
+		{`{"browsers":["a",1]}`, all, false},
+		{`{"browsers":["a",1]}`, fieldSet(0).with(fieldName), true},
+		{`{"name":1}`, all, false},
+		{`{"extra":01}`, all, false},
+		{`{"extra":tru}`, all, false},
+		{`{"name":"N"`, all, false},
+		{`{"name":"N"} x`, all, false},
+		{`{"name" "N"}`, all, false},
+		{`["name"]`, all, false},
+		{`null`, all, false},
+		{``, all, false},
+	}
+
+	for _, c := range cases {
+		view := userView{}
+		if ok := view.scan([]byte(c.line), c.needs); ok != c.ok {
+			t.Errorf("%s: got %v, expected %v", c.line, ok, c.ok)
+		}
+	}
+}
+
+func TestUserViewScanFields(t *testing.T) {
+	line := []byte(`{"browsers":["Android","MSIE 8.0"],"company":"C","country":"X","email":"e@mail","job":"J","name":"Jo","phone":"1"}`)
+
+	view := userView{}
+	if !view.scan(line, fieldSet(0).with(fieldBrowsers, fieldName)) {
+		t.Fatal("unexpected fallback")
+	}
+	if len(view.browsers) != 2 || string(view.browsers[1]) != "MSIE 8.0" {
+		t.Errorf("browsers: got %q", view.browsers)
+	}
+	if string(view.fields[fieldName]) != "Jo" {
+		t.Errorf("name: got %q", view.fields[fieldName])
+	}
+	if view.fields[fieldEmail] != nil || view.fields[fieldCountry] != nil {
+		t.Error("not needed fields must be skipped")
+	}
+}
+
+func TestUserViewScanNulls(t *testing.T) {
+	all := fieldSet(0).with(fieldBrowsers, fieldEmail, fieldName, fieldCompany, fieldCountry, fieldJob, fieldPhone)
+
+	view := userView{}
+	if !view.scan([]byte(`{"browsers":["a"],"name":"N","email":"e@mail","email":null,"browsers":null}`), all) {
+		t.Fatal("unexpected fallback")
+	}
+	if len(view.browsers) != 0 || view.fields[fieldEmail] != nil {
+		t.Errorf("null must clear: got browsers %q, email %q", view.browsers, view.fields[fieldEmail])
+	}
+	if !view.present.has(fieldName) || view.present.has(fieldEmail) || view.present.has(fieldBrowsers) {
+		t.Errorf("present: got %b", view.present)
+	}
+
+	if !view.scan([]byte(`{"name":""}`), all) || !view.present.has(fieldName) {
+		t.Errorf("an empty name must be present, got %b", view.present)
+	}
+	if !view.scan([]byte(`{"email":"e@mail"}`), all) || view.present.has(fieldName) {
+		t.Errorf("a missing name must not be present, got %b", view.present)
+	}
+}
+
+func TestUserViewDecode(t *testing.T) {
+	line := []byte(`{"browsers":["Android",1,null,"MSIE"],"name":null,"email":"e@mail","company":{"x":1},"phone":"😀"}`)
+
+	view := userView{}
+	user := User{}
+	if err := view.decode(line, &user); err != nil {
+		t.Fatal(err)
+	}
+	if len(view.browsers) != 2 || string(view.browsers[0]) != "Android" || string(view.browsers[1]) != "MSIE" {
+		t.Errorf("browsers: got %q", view.browsers)
+	}
+	if view.present.has(fieldName) || view.present.has(fieldCompany) || !view.present.has(fieldEmail) {
+		t.Errorf("present: got %b", view.present)
+	}
+	if user.Phone != "😀" {
+		t.Errorf("phone: got %q", user.Phone)
+	}
+
+	if err := view.decode([]byte(`{"name":"N","name":null,"company":"C","company":1,"browsers":{"a":1}}`), &user); err != nil {
+		t.Fatal(err)
+	}
+	if view.present.has(fieldName) || !view.present.has(fieldCompany) || user.Company != "C" || view.present.has(fieldBrowsers) {
+		t.Errorf("got %+v, present %b", user, view.present)
+	}
+	if err := view.decode([]byte(`{"email":"e@mail"}`), &user); err != nil || view.present.has(fieldName) {
+		t.Errorf("a missing name must not be present, got %b, %v", view.present, err)
+	}
+
+	if err := view.decode([]byte(`{"name":"N"`), &user); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestLineMatcherAllocs(t *testing.T) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	lines := make([][]byte, 0, maxUsers)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+
+	matcher := newLineMatcher(Options{Query: legacyQuery})
+	// the first pass fills the unique browsers set
+	for i, line := range lines {
+		matcher.process(ioutil.Discard, i, line)
+	}
+
+	allocs := testing.AllocsPerRun(10, func() {
+		for i, line := range lines {
+			matcher.process(ioutil.Discard, i, line)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocs per %d lines, expected 0", allocs, len(lines))
+	}
+}
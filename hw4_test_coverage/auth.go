@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials authorize requests to the external system.
+type Credentials interface {
+	// Apply adds credentials to the request, it may be called concurrently
+	Apply(r *http.Request) error
+}
+
+// invalidator is implemented by credentials which can get new ones when
+// the server rejects them.
+type invalidator interface {
+	Invalidate()
+}
+
+func WithCredentials(c Credentials) Option {
+	return func(cfg *clientConfig) {
+		cfg.credentials = c
+	}
+}
+
+// StaticToken sends the token in the AccessToken header, the client does
+// so with its AccessToken without WithCredentials.
+type StaticToken string
+
+func (t StaticToken) Apply(r *http.Request) error {
+	r.Header.Set("AccessToken", string(t))
+	return nil
+}
+
+// BearerToken sends the Authorization: Bearer header with a token issued
+// by TokenURL for the client credentials grant. The token is renewed Leeway
+// before it expires, or after the server rejects it.
+type BearerToken struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Leeway       time.Duration
+	// Client requests tokens, http.DefaultClient if nil
+	Client *http.Client
+
+	// now is time.Now if nil
+	now     func() time.Time
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func NewBearerToken(tokenURL, clientID, clientSecret string) *BearerToken {
+	return &BearerToken{
+		TokenURL:     tokenURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Leeway:       10 * time.Second,
+	}
+}
+
+func (b *BearerToken) clock() time.Time {
+	if b.now == nil {
+		return time.Now()
+	}
+	return b.now()
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	// ExpiresIn is in seconds, 0 if the token doesn't expire
+	ExpiresIn int `json:"expires_in"`
+}
+
+func (b *BearerToken) Apply(r *http.Request) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.token == "" || !b.expires.IsZero() && !b.clock().Before(b.expires.Add(-b.Leeway)) {
+		if err := b.refresh(r); err != nil {
+			return err
+		}
+	}
+	r.Header.Set("Authorization", "Bearer "+b.token)
+	return nil
+}
+
+// refresh gets a new token, r is the request which needs it.
+func (b *BearerToken) refresh(r *http.Request) error {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {b.ClientID},
+		"client_secret": {b.ClientSecret},
+	}
+	tokenReq, err := http.NewRequestWithContext(r.Context(), "POST", b.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("cant create token request: %s", err)
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(tokenReq)
+	if err != nil {
+		return fmt.Errorf("cant get token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("cant get token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token endpoint answered %d: %s", resp.StatusCode, body)
+	}
+
+	token := tokenResponse{}
+	if err := json.Unmarshal(body, &token); err != nil || token.AccessToken == "" {
+		return fmt.Errorf("cant unpack token json: %s", body)
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return fmt.Errorf("unsupported token type %s", token.TokenType)
+	}
+
+	b.token = token.AccessToken
+	b.expires = time.Time{}
+	if token.ExpiresIn > 0 {
+		b.expires = b.clock().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return nil
+}
+
+// Invalidate makes the next request get a new token.
+func (b *BearerToken) Invalidate() {
+	b.mu.Lock()
+	b.token = ""
+	b.mu.Unlock()
+}
+
+// HMACHeader and HMACTimestampHeader carry the signature of HMACSigner.
+const (
+	HMACHeader          = "Signature"
+	HMACTimestampHeader = "Signature-Timestamp"
+)
+
+// HMACSigner signs requests with HMAC-SHA256 of the secret. The signed
+// string is the method, the path with the sorted query and the Unix time
+// of the request, each on its own line:
+//
+//	GET
+//	/?limit=26&offset=0&query=Boyd
+//	1700000000
+//
+// It is sent as "KeyID:base64(signature)" in the Signature header, the time
+// in the Signature-Timestamp header.
+type HMACSigner struct {
+	KeyID  string
+	Secret []byte
+
+	// now is time.Now if nil
+	now func() time.Time
+}
+
+func NewHMACSigner(keyID string, secret []byte) *HMACSigner {
+	return &HMACSigner{KeyID: keyID, Secret: secret}
+}
+
+func (s *HMACSigner) Apply(r *http.Request) error {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+	mac := hmac.New(sha256.New, s.Secret)
+	fmt.Fprintf(mac, "%s\n%s?%s\n%s", r.Method, r.URL.EscapedPath(), r.URL.Query().Encode(), timestamp)
+
+	r.Header.Set(HMACTimestampHeader, timestamp)
+	r.Header.Set(HMACHeader, s.KeyID+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return nil
+}
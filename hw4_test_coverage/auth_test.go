@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// authServer answers with an empty list if check accepts the request.
+func authServer(check func(r *http.Request) bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !check(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "[]")
+	}))
+}
+
+func TestStaticToken(t *testing.T) {
+	ts := authServer(func(r *http.Request) bool { return r.Header.Get("AccessToken") == "static" })
+	defer ts.Close()
+
+	if _, err := NewSearchClient(ts.URL, "ignored", WithCredentials(StaticToken("static"))).FindUsers(defaultReq); err != nil {
+		t.Error("unexpected error:", err)
+	}
+	if _, err := NewSearchClient(ts.URL, "static").FindUsers(defaultReq); err != nil {
+		t.Error("unexpected error:", err)
+	}
+	if _, err := NewSearchClient(ts.URL, "wrong").FindUsers(defaultReq); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got: %v", err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	issued := 0
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Method != "POST" || r.Form.Get("grant_type") != "client_credentials" ||
+			r.Form.Get("client_id") != "id" || r.Form.Get("client_secret") != "secret" {
+			http.Error(w, "bad client", http.StatusBadRequest)
+			return
+		}
+		issued++
+		fmt.Fprintf(w, `{"access_token": "token%d", "token_type": "Bearer", "expires_in": 60}`, issued)
+	}))
+	defer tokens.Close()
+
+	valid := "token1"
+	ts := authServer(func(r *http.Request) bool { return r.Header.Get("Authorization") == "Bearer "+valid })
+	defer ts.Close()
+
+	now := time.Unix(0, 0)
+	creds := NewBearerToken(tokens.URL, "id", "secret")
+	creds.now = func() time.Time { return now }
+	srv := NewSearchClient(ts.URL, "", WithCredentials(creds))
+
+	for i := 0; i < 2; i++ {
+		if _, err := srv.FindUsers(defaultReq); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if issued != 1 {
+		t.Errorf("expected one token, got %d", issued)
+	}
+
+	// renewed Leeway before it expires
+	now = now.Add(55 * time.Second)
+	valid = "token2"
+	if _, err := srv.FindUsers(defaultReq); err != nil || issued != 2 {
+		t.Errorf("expected a new token, got %d tokens, %v", issued, err)
+	}
+
+	// revoked by the server
+	valid = "token3"
+	if _, err := srv.FindUsers(defaultReq); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got: %v", err)
+	}
+	if _, err := srv.FindUsers(defaultReq); err != nil || issued != 3 {
+		t.Errorf("expected a new token after 401, got %d tokens, %v", issued, err)
+	}
+
+	bad := NewBearerToken(tokens.URL, "id", "wrong")
+	if _, err := NewSearchClient(ts.URL, "", WithCredentials(bad)).FindUsers(defaultReq); err == nil ||
+		!strings.Contains(err.Error(), "token endpoint answered 400") {
+		t.Errorf("expected token endpoint error, got: %v", err)
+	}
+}
+
+func TestBearerTokenErrors(t *testing.T) {
+	responses := []string{
+		`{"access_token": ""}`,
+		`{"access_token": "x", "token_type": "mac"}`,
+		`not json`,
+	}
+	for _, body := range responses {
+		tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, body)
+		}))
+		_, err := NewSearchClient("http://search.test", "", WithCredentials(NewBearerToken(tokens.URL, "id", "secret"))).FindUsers(defaultReq)
+		if err == nil || errors.Is(err, ErrUnauthorized) {
+			t.Errorf("%s: expected token error, got: %v", body, err)
+		}
+		tokens.Close()
+	}
+
+	// a token without expiry is kept
+	issued := 0
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issued++
+		io.WriteString(w, `{"access_token": "forever"}`)
+	}))
+	defer tokens.Close()
+	ts := authServer(func(r *http.Request) bool { return r.Header.Get("Authorization") == "Bearer forever" })
+	defer ts.Close()
+	creds := NewBearerToken(tokens.URL, "id", "secret")
+	creds.Client = tokens.Client()
+	now := time.Unix(0, 0)
+	creds.now = func() time.Time { return now }
+	srv := NewSearchClient(ts.URL, "", WithCredentials(creds))
+	for i := 0; i < 2; i++ {
+		now = now.Add(time.Hour)
+		if _, err := srv.FindUsers(defaultReq); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if issued != 1 {
+		t.Errorf("expected one token, got %d", issued)
+	}
+
+	for _, tokenURL := range []string{"http://bad url", "http://127.0.0.1:0"} {
+		_, err := NewSearchClient(ts.URL, "", WithCredentials(NewBearerToken(tokenURL, "id", "secret"))).FindUsers(defaultReq)
+		if err == nil {
+			t.Errorf("%s: expected error", tokenURL)
+		}
+	}
+}
+
+func TestHMACSigner(t *testing.T) {
+	secret := []byte("secret")
+	ts := authServer(func(r *http.Request) bool {
+		mac := hmac.New(sha256.New, secret)
+		io.WriteString(mac, "GET\n/search?limit=2&offset=0&order_by=0&order_field=&query=Boyd\n1700000000")
+		expected := "key:" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		return r.Header.Get(HMACTimestampHeader) == "1700000000" && r.Header.Get(HMACHeader) == expected
+	})
+	defer ts.Close()
+
+	signer := NewHMACSigner("key", secret)
+	signer.now = func() time.Time { return time.Unix(1700000000, 0) }
+	if _, err := NewSearchClient(ts.URL+"/search", "", WithCredentials(signer)).FindUsers(SearchRequest{Limit: 1, Query: "Boyd"}); err != nil {
+		t.Error("unexpected error:", err)
+	}
+
+	other := NewHMACSigner("key", []byte("other"))
+	other.now = signer.now
+	if _, err := NewSearchClient(ts.URL+"/search", "", WithCredentials(other)).FindUsers(SearchRequest{Limit: 1, Query: "Boyd"}); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got: %v", err)
+	}
+}
+
+func TestCredentialsLiterals(t *testing.T) {
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"access_token": "token", "expires_in": 60}`)
+	}))
+	defer tokens.Close()
+	ts := authServer(func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer token" || r.Header.Get(HMACHeader) != ""
+	})
+	defer ts.Close()
+
+	for _, creds := range []Credentials{
+		&BearerToken{TokenURL: tokens.URL, ClientID: "id", ClientSecret: "secret"},
+		&HMACSigner{KeyID: "key", Secret: []byte("secret")},
+	} {
+		srv := NewSearchClient(ts.URL, "", WithCredentials(creds))
+		for i := 0; i < 2; i++ {
+			if _, err := srv.FindUsers(defaultReq); err != nil {
+				t.Errorf("%T: unexpected error: %v", creds, err)
+			}
+		}
+	}
+}
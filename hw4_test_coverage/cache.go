@@ -0,0 +1,186 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errNotModified is returned by search when the server confirms the cached
+// response with 304.
+var errNotModified = errors.New("not modified")
+
+// CacheConfig configures the response cache of WithCache.
+type CacheConfig struct {
+	// TTL is how long a response is returned without asking the server,
+	// then it is revalidated with If-None-Match if the server sent an ETag
+	TTL time.Duration
+	// MaxEntries bounds the number of cached responses, the least recently
+	// used one is evicted first, 0 means no limit
+	MaxEntries int
+}
+
+// CacheStats counts the searches made through the cache.
+type CacheStats struct {
+	// Hits are answered from the cache without a request
+	Hits int64
+	// Revalidations are answered from the cache after the server replied
+	// 304 Not Modified
+	Revalidations int64
+	// Misses needed a full response or failed
+	Misses int64
+}
+
+// HitRatio is the share of searches answered from the cache, with or
+// without revalidation.
+func (s CacheStats) HitRatio() float64 {
+	total := s.Hits + s.Revalidations + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits+s.Revalidations) / float64(total)
+}
+
+// WithCache caches responses by the normalised request, identical requests
+// get the same response until TTL passes.
+func WithCache(cfg CacheConfig) Option {
+	return func(c *clientConfig) {
+		c.cache = &responseCache{
+			ttl:     cfg.TTL,
+			max:     cfg.MaxEntries,
+			now:     time.Now,
+			lru:     list.New(),
+			entries: make(map[string]*list.Element),
+		}
+	}
+}
+
+// CacheStats returns the counters of the cache, zero without WithCache.
+func (srv *SearchClient) CacheStats() CacheStats {
+	if srv.cache == nil {
+		return CacheStats{}
+	}
+	srv.cache.mu.Lock()
+	defer srv.cache.mu.Unlock()
+	return srv.cache.stats
+}
+
+// responseCache is an LRU of responses by the encoded query, a nil cache
+// caches nothing.
+type responseCache struct {
+	ttl time.Duration
+	max int
+	now func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	stats   CacheStats
+}
+
+type cacheEntry struct {
+	key     string
+	resp    SearchResponse
+	etag    string
+	expires time.Time
+}
+
+// get returns a fresh response, or the ETag to revalidate a stale one with.
+func (c *responseCache) get(key string) (resp *SearchResponse, etag string) {
+	if c == nil {
+		return nil, ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, ""
+	}
+	entry := elem.Value.(*cacheEntry)
+	if c.now().Before(entry.expires) {
+		c.lru.MoveToFront(elem)
+		c.stats.Hits++
+		return entry.resp.copy(), ""
+	}
+	if entry.etag == "" {
+		c.remove(elem)
+		return nil, ""
+	}
+	return nil, entry.etag
+}
+
+// revalidated renews the stale response confirmed by the server, nil if it
+// was evicted meanwhile.
+func (c *responseCache) revalidated(key string) *SearchResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+	entry.expires = c.now().Add(c.ttl)
+	c.lru.MoveToFront(elem)
+	c.stats.Revalidations++
+	return entry.resp.copy()
+}
+
+func (c *responseCache) put(key string, resp *SearchResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Misses++
+	if resp == nil {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:     key,
+		resp:    *resp.copy(),
+		etag:    resp.etag,
+		expires: c.now().Add(c.ttl),
+	})
+	for c.max > 0 && c.lru.Len() > c.max {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *responseCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+// copy keeps the cached users safe from changes by the caller.
+func (r *SearchResponse) copy() *SearchResponse {
+	copied := *r
+	copied.Users = append([]User(nil), r.Users...)
+	return &copied
+}
+
+// cachedSearch returns the cached response for params if it is fresh or the
+// server confirms it, otherwise the result of search.
+func (srv *SearchClient) cachedSearch(params string, search func(etag string) (*SearchResponse, error)) (*SearchResponse, error) {
+	if srv.cache == nil {
+		return search("")
+	}
+
+	resp, etag := srv.cache.get(params)
+	if resp != nil {
+		return resp, nil
+	}
+	resp, err := search(etag)
+	if errors.Is(err, errNotModified) {
+		if resp := srv.cache.revalidated(params); resp != nil {
+			return resp, nil
+		}
+		// evicted meanwhile, ask for the whole response
+		resp, err = search("")
+	}
+	srv.cache.put(params, resp)
+	return resp, err
+}
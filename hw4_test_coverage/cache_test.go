@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Willsem/golang-coursera/hw4_test_coverage/server"
+)
+
+// countingServer serves the dataset and counts full responses and 304s.
+func countingServer(t *testing.T) (*httptest.Server, *int32, *int32) {
+	handler := server.New(loadDataset(t), AccessToken)
+	var full, notModified int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code == http.StatusNotModified {
+			atomic.AddInt32(&notModified, 1)
+		} else {
+			atomic.AddInt32(&full, 1)
+		}
+		for key, values := range rec.Header() {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &full, &notModified
+}
+
+func TestCache(t *testing.T) {
+	ts, full, notModified := countingServer(t)
+
+	now := time.Unix(0, 0)
+	srv := NewSearchClient(ts.URL, AccessToken, WithCache(CacheConfig{TTL: time.Minute, MaxEntries: 2}))
+	srv.cache.now = func() time.Time { return now }
+
+	req := SearchRequest{Limit: 3, OrderField: "Age", OrderBy: OrderByAsc}
+	first, err := srv.FindUsers(req)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	first.Users[0].Name = "changed"
+
+	resp, err := srv.FindUsers(req)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if *full != 1 || len(resp.Users) != 3 || resp.Users[0].Name == "changed" || !resp.NextPage {
+		t.Errorf("expected a cached copy, got %+v after %d requests", resp, *full)
+	}
+
+	// stale, the server confirms it
+	now = now.Add(2 * time.Minute)
+	resp, err = srv.FindUsers(req)
+	if err != nil || len(resp.Users) != 3 || *full != 1 || *notModified != 1 {
+		t.Errorf("expected revalidation, got %+v, %v", resp, err)
+	}
+	if _, err := srv.FindUsers(req); err != nil || *full != 1 || *notModified != 1 {
+		t.Errorf("expected a fresh entry after revalidation, got error %v", err)
+	}
+
+	// Limit 3 and 2 are different requests, the third one evicts the first
+	for _, limit := range []int{2, 1} {
+		if _, err := srv.FindUsers(SearchRequest{Limit: limit, OrderField: "Age", OrderBy: OrderByAsc}); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+	}
+	if _, err := srv.FindUsers(req); err != nil || *full != 4 {
+		t.Errorf("expected the evicted request to be sent, got %d requests, %v", *full, err)
+	}
+
+	stats := srv.CacheStats()
+	expected := CacheStats{Hits: 2, Revalidations: 1, Misses: 4}
+	if stats != expected || stats.HitRatio() != 3.0/7 {
+		t.Errorf("got %+v, expected %+v", stats, expected)
+	}
+}
+
+func TestCacheErrors(t *testing.T) {
+	ts, full, _ := countingServer(t)
+
+	srv := NewSearchClient(ts.URL, AccessToken, WithCache(CacheConfig{TTL: time.Minute}))
+	for i := 0; i < 2; i++ {
+		if _, err := srv.FindUsers(SearchRequest{Limit: 1, OrderField: "About"}); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if *full != 2 || srv.CacheStats().Misses != 2 {
+		t.Errorf("errors must not be cached, got %d requests, %+v", *full, srv.CacheStats())
+	}
+
+	// without an ETag stale entries are dropped
+	plain := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer plain.Close()
+	now := time.Unix(0, 0)
+	srv = NewSearchClient(plain.URL, AccessToken, WithCache(CacheConfig{TTL: time.Minute}))
+	srv.cache.now = func() time.Time { return now }
+	srv.FindUsers(defaultReq)
+	now = now.Add(time.Hour)
+	srv.FindUsers(defaultReq)
+	if stats := srv.CacheStats(); stats.Misses != 2 || stats.Hits != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	if stats := NewSearchClient(plain.URL, AccessToken).CacheStats(); stats != (CacheStats{}) || stats.HitRatio() != 0 {
+		t.Errorf("unexpected stats without cache: %+v", stats)
+	}
+}
+
+func TestCacheEvictedWhileRevalidating(t *testing.T) {
+	now := time.Unix(0, 0)
+	srv := NewSearchClient("", AccessToken, WithCache(CacheConfig{TTL: time.Minute}))
+	srv.cache.now = func() time.Time { return now }
+	srv.cache.put("key", &SearchResponse{Users: []User{{Id: 1}}, etag: `"1"`})
+	now = now.Add(time.Hour)
+
+	etags := []string{}
+	resp, err := srv.cachedSearch("key", func(etag string) (*SearchResponse, error) {
+		etags = append(etags, etag)
+		if etag != "" {
+			// another search evicted the entry meanwhile
+			srv.cache.remove(srv.cache.entries["key"])
+			return nil, errNotModified
+		}
+		return &SearchResponse{Users: []User{{Id: 2}}}, nil
+	})
+	if err != nil || len(resp.Users) != 1 || resp.Users[0].Id != 2 {
+		t.Fatalf("unexpected response: %+v, %v", resp, err)
+	}
+	if len(etags) != 2 || etags[0] != `"1"` || etags[1] != "" {
+		t.Errorf("unexpected requests: %q", etags)
+	}
+}
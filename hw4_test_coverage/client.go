@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,9 +19,12 @@ const (
 	orderDesc
 )
 
+const defaultTimeout = time.Second
+
 var (
 	errTest = errors.New("testing")
-	client  = &http.Client{Timeout: time.Second}
+	// used by clients which are not created by NewSearchClient
+	defaultClient = &http.Client{Timeout: defaultTimeout}
 )
 
 type User struct {
@@ -33,10 +38,16 @@ type User struct {
 type SearchResponse struct {
 	Users    []User
 	NextPage bool
+	// NextCursor requests the next page in cursor mode, empty on the last one
+	NextCursor string
+
+	etag string
 }
 
 type SearchErrorResponse struct {
 	Error string
+	// Field is the invalid parameter or sort field if the server knows it
+	Field string
 }
 
 const (
@@ -45,6 +56,14 @@ const (
 	OrderByDesc = 1
 
 	ErrorBadOrderField = `OrderField invalid`
+
+	// OrderFieldRelevance ranks users by how well they match the words of
+	// Query, the most relevant first, OrderBy is ignored
+	OrderFieldRelevance = "Relevance"
+
+	// CursorStart as SearchRequest.Cursor requests the first page of
+	// cursor pagination
+	CursorStart = "*"
 )
 
 type SearchRequest struct {
@@ -54,6 +73,26 @@ type SearchRequest struct {
 	OrderField string
 	// -1 по убыванию, 0 как встретилось, 1 по возрастанию
 	OrderBy int
+
+	// filters, zero values don't filter
+	MinAge int
+	MaxAge int
+	Gender string // male или female
+	Ids    []int
+	// Sort orders by several fields, the first one first, and overrides
+	// OrderField and OrderBy
+	Sort []SortField
+
+	// Cursor switches from Offset to cursor pagination: CursorStart for the
+	// first page, then NextCursor of the previous response
+	Cursor string
+}
+
+// SortField is a field of a multi-field sort, OrderBy is OrderByAsc or
+// OrderByDesc.
+type SortField struct {
+	Field   string
+	OrderBy int
 }
 
 type SearchClient struct {
@@ -61,10 +100,104 @@ type SearchClient struct {
 	AccessToken string
 	// урл внешней системы, куда идти
 	URL string
+
+	client  *http.Client
+	header  http.Header
+	retry   *RetryPolicy
+	breaker *circuitBreaker
+	cache   *responseCache
+	// credentials replace AccessToken if set
+	credentials Credentials
+}
+
+// Option configures a SearchClient created by NewSearchClient.
+type Option func(*clientConfig)
+
+type clientConfig struct {
+	client    *http.Client
+	transport http.RoundTripper
+	timeout   time.Duration
+	header    http.Header
+	retry     *RetryPolicy
+	breaker   *circuitBreaker
+	cache     *responseCache
+
+	credentials Credentials
+}
+
+// WithHTTPClient makes the client send requests with c. Other options
+// change a copy of c, not c itself.
+func WithHTTPClient(c *http.Client) Option {
+	return func(cfg *clientConfig) {
+		cfg.client = c
+	}
+}
+
+func WithTransport(t http.RoundTripper) Option {
+	return func(cfg *clientConfig) {
+		cfg.transport = t
+	}
+}
+
+// WithTimeout limits the time of a whole request, 0 means no limit.
+func WithTimeout(d time.Duration) Option {
+	return func(cfg *clientConfig) {
+		cfg.timeout = d
+	}
+}
+
+// WithHeader adds a header to every request.
+func WithHeader(key, value string) Option {
+	return func(cfg *clientConfig) {
+		cfg.header.Add(key, value)
+	}
+}
+
+func NewSearchClient(serverURL, accessToken string, opts ...Option) *SearchClient {
+	cfg := clientConfig{timeout: -1, header: http.Header{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	client := &http.Client{Timeout: defaultTimeout}
+	if cfg.client != nil {
+		copied := *cfg.client
+		client = &copied
+	}
+	if cfg.transport != nil {
+		client.Transport = cfg.transport
+	}
+	if cfg.timeout >= 0 {
+		client.Timeout = cfg.timeout
+	}
+
+	return &SearchClient{
+		AccessToken: accessToken,
+		URL:         serverURL,
+		client:      client,
+		header:      cfg.header,
+		retry:       cfg.retry,
+		breaker:     cfg.breaker,
+		cache:       cfg.cache,
+		credentials: cfg.credentials,
+	}
+}
+
+func (srv *SearchClient) httpClient() *http.Client {
+	if srv.client != nil {
+		return srv.client
+	}
+	return defaultClient
 }
 
 // FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользоваталей
 func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	return srv.FindUsersContext(context.Background(), req)
+}
+
+// FindUsersContext works like FindUsers, the request is aborted once ctx is
+// done and ctx.Err() is returned.
+func (srv *SearchClient) FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
 
 	searcherParams := url.Values{}
 
@@ -79,40 +212,144 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 	}
 
 	//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
-	req.Limit++
+	if req.Cursor == "" {
+		req.Limit++
+	} else {
+		// the server tells if there is a next page itself
+		searcherParams.Add("cursor", req.Cursor)
+	}
 
 	searcherParams.Add("limit", strconv.Itoa(req.Limit))
 	searcherParams.Add("offset", strconv.Itoa(req.Offset))
 	searcherParams.Add("query", req.Query)
 	searcherParams.Add("order_field", req.OrderField)
 	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+	if err := addFilters(searcherParams, req); err != nil {
+		return nil, err
+	}
+
+	return srv.cachedSearch(searcherParams.Encode(), func(etag string) (*SearchResponse, error) {
+		return srv.withRetries(ctx, func() (*SearchResponse, error) {
+			return srv.search(ctx, req, searcherParams, etag)
+		})
+	})
+}
 
-	searcherReq, err := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil)
-	searcherReq.Header.Add("AccessToken", srv.AccessToken)
-	
-	resp, err := client.Do(searcherReq)
+// addFilters adds only the filters and sort fields which are set, so
+// servers without them keep working for plain requests.
+func addFilters(params url.Values, req SearchRequest) error {
+	if req.MinAge < 0 || req.MaxAge < 0 {
+		return fmt.Errorf("age must be >= 0")
+	}
+	if req.MinAge > 0 {
+		params.Add("min_age", strconv.Itoa(req.MinAge))
+	}
+	if req.MaxAge > 0 {
+		params.Add("max_age", strconv.Itoa(req.MaxAge))
+	}
+	if req.Gender != "" {
+		params.Add("gender", req.Gender)
+	}
+	if len(req.Ids) > 0 {
+		ids := make([]string, len(req.Ids))
+		for i, id := range req.Ids {
+			ids[i] = strconv.Itoa(id)
+		}
+		params.Add("ids", strings.Join(ids, ","))
+	}
+
+	if len(req.Sort) > 0 {
+		fields := make([]string, len(req.Sort))
+		for i, f := range req.Sort {
+			switch f.OrderBy {
+			case OrderByAsc:
+				fields[i] = f.Field + ":asc"
+			case OrderByDesc:
+				fields[i] = f.Field + ":desc"
+			default:
+				return fmt.Errorf("sort by %s must be ascending or descending", f.Field)
+			}
+		}
+		params.Add("sort", strings.Join(fields, ","))
+	}
+	return nil
+}
+
+// search makes a single request to the external system, with a non-empty
+// etag it returns errNotModified if the response is the same.
+func (srv *SearchClient) search(ctx context.Context, req SearchRequest, searcherParams url.Values, etag string) (*SearchResponse, error) {
+	searcherReq, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"?"+searcherParams.Encode(), nil)
 	if err != nil {
+		return nil, fmt.Errorf("cant create request: %s", err)
+	}
+	for key, values := range srv.header {
+		for _, value := range values {
+			searcherReq.Header.Add(key, value)
+		}
+	}
+	if srv.credentials != nil {
+		if err := srv.credentials.Apply(searcherReq); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("cant apply credentials: %w", err)
+		}
+	} else {
+		searcherReq.Header.Set("AccessToken", srv.AccessToken)
+	}
+	if etag != "" {
+		searcherReq.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := srv.httpClient().Do(searcherReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
+			return nil, &TimeoutError{Query: searcherParams.Encode(), Err: err}
 		}
-		return nil, fmt.Errorf("unknown error %s", err)
+		return nil, fmt.Errorf("unknown error %w", err)
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("cant read response body: %w", err)
+	}
 
-	switch resp.StatusCode {
-	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad AccessToken")
-	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("SearchServer fatal error")
-	case http.StatusBadRequest:
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return nil, errNotModified
+	case resp.StatusCode == http.StatusUnauthorized:
+		if c, ok := srv.credentials.(invalidator); ok {
+			c.Invalidate()
+		}
+		return nil, ErrUnauthorized
+	case resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests:
+		return nil, &ServerError{
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	case resp.StatusCode == http.StatusBadRequest:
 		errResp := SearchErrorResponse{}
 		err = json.Unmarshal(body, &errResp)
 		if err != nil {
 			return nil, fmt.Errorf("cant unpack error json: %s", err)
 		}
-		if errResp.Error == "ErrorBadOrderField" {
-			return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+		switch errResp.Error {
+		case "ErrorBadOrderField":
+			if errResp.Field != "" {
+				return nil, &BadOrderFieldError{Field: errResp.Field}
+			}
+			return nil, &BadOrderFieldError{Field: req.OrderField}
+		case "ErrorBadFilter":
+			return nil, &BadFilterError{Field: errResp.Field}
+		case "ErrorBadCursor":
+			return nil, ErrBadCursor
 		}
 		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
 	}
@@ -123,8 +360,12 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 		return nil, fmt.Errorf("cant unpack result json: %s", err)
 	}
 
-	result := SearchResponse{}
-	if len(data) == req.Limit {
+	result := SearchResponse{etag: resp.Header.Get("ETag")}
+	if req.Cursor != "" {
+		result.Users = data
+		result.NextCursor = resp.Header.Get("Next-Cursor")
+		result.NextPage = result.NextCursor != ""
+	} else if len(data) == req.Limit {
 		result.NextPage = true
 		result.Users = data[0 : len(data)-1]
 	} else {
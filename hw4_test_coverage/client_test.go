@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,6 +15,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/Willsem/golang-coursera/hw4_test_coverage/server"
 )
 
 type Content struct {
@@ -179,6 +183,9 @@ func TestFindUsersUnauthorized(t *testing.T) {
 	if err.Error() != "Bad AccessToken" {
 		t.Error("incorrect error:\n\tgot:", err.Error(), "\n\texpected: Bad AccessToken")
 	}
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Error("expected ErrUnauthorized")
+	}
 }
 
 func emptyServer(w http.ResponseWriter, r *http.Request) {
@@ -264,3 +271,281 @@ func errorTest(t *testing.T, URL string) {
 		t.Error("expected error")
 	}
 }
+
+func TestFindUsersContextCanceled(t *testing.T) {
+	started := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	srv := NewSearchClient(ts.URL, AccessToken, WithTimeout(0))
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	_, err := srv.FindUsersContext(ctx, defaultReq)
+	if err != context.Canceled {
+		t.Errorf("incorrect error:\n\tgot: %v\n\texpected: %v", err, context.Canceled)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestNewSearchClientOptions(t *testing.T) {
+	var got *http.Request
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		got = r
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(`[{"Id":1}]`)),
+		}, nil
+	})
+	base := &http.Client{Timeout: time.Minute}
+
+	srv := NewSearchClient("http://search.local/users", AccessToken,
+		WithHTTPClient(base),
+		WithTransport(transport),
+		WithHeader("X-Request-Source", "test"),
+		WithHeader("AccessToken", "overridden"),
+	)
+
+	resp, err := srv.FindUsers(SearchRequest{Limit: 5})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(resp.Users) != 1 || resp.NextPage {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if got.Header.Get("X-Request-Source") != "test" || got.Header.Get("AccessToken") != AccessToken {
+		t.Errorf("unexpected headers: %v", got.Header)
+	}
+	if base.Transport != nil {
+		t.Error("the given http.Client must not be changed")
+	}
+	if srv.client.Timeout != time.Minute {
+		t.Errorf("timeout: got %s, expected %s", srv.client.Timeout, time.Minute)
+	}
+}
+
+func TestNewSearchClientTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	srv := NewSearchClient(ts.URL, AccessToken, WithTimeout(10*time.Millisecond))
+	_, err := srv.FindUsers(defaultReq)
+	if err == nil || !strings.HasPrefix(err.Error(), "timeout for") {
+		t.Errorf("expected timeout error, got: %v", err)
+	}
+}
+
+func TestFindUsersBadURL(t *testing.T) {
+	errorTest(t, "http://[::1")
+}
+
+func TestFindUsersTypedErrors(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(internalServer))
+	defer internal.Close()
+	badRequest := httptest.NewServer(http.HandlerFunc(badRequestServer))
+	defer badRequest.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	_, err := NewSearchClient(internal.URL, AccessToken).FindUsers(defaultReq)
+	serverErr := &ServerError{}
+	if !errors.Is(err, ErrServer) || !errors.As(err, &serverErr) {
+		t.Fatalf("expected ServerError, got: %v", err)
+	}
+	if serverErr.StatusCode != http.StatusInternalServerError || serverErr.Body != "Internal error\n" || err.Error() != "SearchServer fatal error" {
+		t.Errorf("unexpected error: %+v", serverErr)
+	}
+
+	_, err = NewSearchClient(badRequest.URL, AccessToken).FindUsers(SearchRequest{Limit: 1, OrderField: "Email"})
+	orderErr := &BadOrderFieldError{}
+	if !errors.Is(err, ErrBadOrderField) || !errors.As(err, &orderErr) {
+		t.Fatalf("expected BadOrderFieldError, got: %v", err)
+	}
+	if orderErr.Field != "Email" || err.Error() != "OrderFeld Email invalid" {
+		t.Errorf("unexpected error: %+v", orderErr)
+	}
+
+	_, err = NewSearchClient(slow.URL, AccessToken, WithTimeout(10*time.Millisecond)).FindUsers(defaultReq)
+	timeoutErr := &TimeoutError{}
+	if !errors.Is(err, ErrTimeout) || !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected TimeoutError, got: %v", err)
+	}
+	if timeoutErr.Query == "" || timeoutErr.Unwrap() == nil {
+		t.Errorf("unexpected error: %+v", timeoutErr)
+	}
+}
+
+// loadDataset reads the users of package server from dataFile.
+func loadDataset(t *testing.T) []server.User {
+	users, err := server.LoadFile(dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+// newSearchServer serves the dataset with package server until the test
+// ends.
+func newSearchServer(t *testing.T) *httptest.Server {
+	ts := httptest.NewServer(server.New(loadDataset(t), AccessToken))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestFindUsersSearchServer(t *testing.T) {
+	ts := newSearchServer(t)
+	srv := NewSearchClient(ts.URL, AccessToken)
+
+	resp, err := srv.FindUsers(SearchRequest{Limit: 2, Offset: 0, Query: "Boyd", OrderField: "Id", OrderBy: OrderByAsc})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(resp.Users) != 1 || resp.Users[0].Name != "Boyd Wolf" || resp.NextPage {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	resp, err = srv.FindUsers(SearchRequest{Limit: 10, Offset: 30, OrderField: "Age", OrderBy: OrderByDesc})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(resp.Users) != 5 || resp.NextPage {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	resp, err = srv.FindUsers(SearchRequest{Limit: 1, Query: "wolf", OrderField: OrderFieldRelevance})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(resp.Users) != 1 || resp.Users[0].Name != "Boyd Wolf" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	_, err = srv.FindUsers(SearchRequest{Limit: 1, OrderField: "About"})
+	if !errors.Is(err, ErrBadOrderField) {
+		t.Errorf("expected ErrBadOrderField, got: %v", err)
+	}
+}
+
+func TestFindUsersFilters(t *testing.T) {
+	ts := newSearchServer(t)
+	srv := NewSearchClient(ts.URL, AccessToken)
+
+	resp, err := srv.FindUsers(SearchRequest{
+		Limit:  25,
+		MinAge: 30,
+		MaxAge: 35,
+		Gender: "female",
+		Sort:   []SortField{{"Age", OrderByDesc}, {"Name", OrderByAsc}},
+	})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(resp.Users) == 0 {
+		t.Fatal("expected users")
+	}
+	for i, u := range resp.Users {
+		if u.Age < 30 || u.Age > 35 || u.Gender != "female" {
+			t.Errorf("unexpected user: %+v", u)
+		}
+		if i > 0 {
+			prev := resp.Users[i-1]
+			if prev.Age < u.Age || prev.Age == u.Age && prev.Name > u.Name {
+				t.Errorf("users %d and %d are out of order", prev.Id, u.Id)
+			}
+		}
+	}
+
+	resp, err = srv.FindUsers(SearchRequest{Limit: 25, Ids: []int{5, 0, 7}, OrderField: "Id", OrderBy: OrderByAsc})
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(resp.Users) != 3 || resp.Users[0].Id != 0 || resp.Users[2].Id != 7 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	_, err = srv.FindUsers(SearchRequest{Limit: 1, Gender: "unknown"})
+	filterErr := &BadFilterError{}
+	if !errors.Is(err, ErrBadFilter) || !errors.As(err, &filterErr) || filterErr.Field != "gender" {
+		t.Errorf("expected BadFilterError for gender, got: %v", err)
+	}
+
+	_, err = srv.FindUsers(SearchRequest{Limit: 1, OrderField: "Id", Sort: []SortField{{"About", OrderByAsc}}})
+	orderErr := &BadOrderFieldError{}
+	if !errors.As(err, &orderErr) || orderErr.Field != "About" {
+		t.Errorf("expected BadOrderFieldError for About, got: %v", err)
+	}
+
+	for _, req := range []SearchRequest{
+		{Limit: 1, MinAge: -1},
+		{Limit: 1, Sort: []SortField{{"Age", OrderByAsIs}}},
+	} {
+		if _, err := srv.FindUsers(req); err == nil {
+			t.Errorf("%+v: expected error", req)
+		}
+	}
+}
+
+func TestFindUsersCursor(t *testing.T) {
+	ts := newSearchServer(t)
+	srv := NewSearchClient(ts.URL, AccessToken)
+
+	req := SearchRequest{Limit: 10, OrderField: "Age", OrderBy: OrderByAsc, Cursor: CursorStart}
+	seen := map[int]bool{}
+	pages := 0
+	for {
+		resp, err := srv.FindUsers(req)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		pages++
+		for _, u := range resp.Users {
+			if seen[u.Id] {
+				t.Errorf("user %d is returned twice", u.Id)
+			}
+			seen[u.Id] = true
+		}
+		if resp.NextPage != (resp.NextCursor != "") {
+			t.Errorf("unexpected response: %+v", resp)
+		}
+		if !resp.NextPage {
+			break
+		}
+		req.Cursor = resp.NextCursor
+	}
+	if len(seen) != len(content.Users) || pages != 4 {
+		t.Errorf("got %d users in %d pages", len(seen), pages)
+	}
+
+	it := srv.Iterate(context.Background(), SearchRequest{Limit: 7, Cursor: CursorStart}, 0)
+	defer it.Close()
+	count := 0
+	for it.Next() {
+		if it.User().Id != count {
+			t.Errorf("got user %d, expected %d", it.User().Id, count)
+		}
+		count++
+	}
+	if it.Err() != nil || count != len(content.Users) {
+		t.Errorf("got %d users, error: %v", count, it.Err())
+	}
+
+	_, err := srv.FindUsers(SearchRequest{Limit: 1, Cursor: "bad"})
+	if !errors.Is(err, ErrBadCursor) {
+		t.Errorf("expected ErrBadCursor, got: %v", err)
+	}
+}
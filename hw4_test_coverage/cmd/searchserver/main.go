@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/Willsem/golang-coursera/hw4_test_coverage/server"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	data := flag.String("data", "dataset.xml", "users dataset")
+	token := flag.String("token", os.Getenv("SEARCH_ACCESS_TOKEN"), "access token expected from clients, $SEARCH_ACCESS_TOKEN by default")
+	noAuth := flag.Bool("no-auth", false, "accept requests without checking the access token")
+	flag.Parse()
+
+	if *token == "" && !*noAuth {
+		log.Fatal("no access token, set -token or $SEARCH_ACCESS_TOKEN, or pass -no-auth")
+	}
+	if *noAuth {
+		*token = ""
+	}
+
+	users, err := server.LoadFile(*data)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("serving %d users on %s", len(users), *addr)
+	log.Fatal(http.ListenAndServe(*addr, server.New(users, *token)))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors returned by FindUsers, check them with errors.Is. Details are
+// available with errors.As through the error types below.
+var (
+	ErrUnauthorized  = errors.New("Bad AccessToken")
+	ErrBadOrderField = errors.New(ErrorBadOrderField)
+	ErrBadFilter     = errors.New("filter invalid")
+	ErrTimeout       = errors.New("timeout")
+	ErrServer        = errors.New("SearchServer fatal error")
+	ErrCircuitOpen   = errors.New("circuit breaker is open")
+	// ErrBadCursor means the cursor is malformed or belongs to another search
+	ErrBadCursor = errors.New("cursor invalid")
+)
+
+// BadOrderFieldError is returned when the server rejects the order field.
+type BadOrderFieldError struct {
+	Field string
+}
+
+func (e *BadOrderFieldError) Error() string {
+	return fmt.Sprintf("OrderFeld %s invalid", e.Field)
+}
+
+func (e *BadOrderFieldError) Is(target error) bool {
+	return target == ErrBadOrderField
+}
+
+// BadFilterError is returned when the server rejects a filter, Field is
+// the name of its query parameter.
+type BadFilterError struct {
+	Field string
+}
+
+func (e *BadFilterError) Error() string {
+	return fmt.Sprintf("filter %s invalid", e.Field)
+}
+
+func (e *BadFilterError) Is(target error) bool {
+	return target == ErrBadFilter
+}
+
+// TimeoutError is returned when the server doesn't answer in time.
+type TimeoutError struct {
+	// Query holds the encoded request parameters
+	Query string
+	Err   error
+}
+
+func (e *TimeoutError) Error() string {
+	return "timeout for " + e.Query
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// ServerError is returned when the server fails with 5xx or asks to slow
+// down with 429.
+type ServerError struct {
+	StatusCode int
+	Body       string
+	// RetryAfter is the delay asked by the Retry-After header, 0 if none
+	RetryAfter time.Duration
+}
+
+func (e *ServerError) Error() string {
+	return ErrServer.Error()
+}
+
+func (e *ServerError) Is(target error) bool {
+	return target == ErrServer
+}
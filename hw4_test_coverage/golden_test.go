@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Willsem/golang-coursera/hw4_test_coverage/replay"
+)
+
+var update = flag.Bool("update", false, "record golden files of replay tests against the search server")
+
+const goldenFile = "testdata/replay/search.json"
+
+var recordOnce sync.Once
+
+// goldenRequests are recorded to goldenFile.
+var goldenRequests = []SearchRequest{
+	{Limit: 2, Query: "Boyd"},
+	{Limit: 3, Offset: 30, OrderField: "Age", OrderBy: OrderByDesc},
+	{Limit: 5, Gender: "female", MinAge: 30, OrderField: "Name", OrderBy: OrderByAsc},
+	{Limit: 1, OrderField: "About"},
+}
+
+// replayClient returns a client replaying goldenFile with faults, with
+// -update the file is recorded first.
+func replayClient(t *testing.T, faults replay.Faults, opts ...Option) *SearchClient {
+	if *update {
+		recordOnce.Do(func() { record(t) })
+	}
+	rep, err := replay.Load(goldenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]Option{WithTransport(replay.Inject(rep, faults))}, opts...)
+	return NewSearchClient("http://search.test/", AccessToken, opts...)
+}
+
+func record(t *testing.T) {
+	ts := newSearchServer(t)
+
+	rec := replay.Record(http.DefaultTransport)
+	srv := NewSearchClient(ts.URL+"/", AccessToken, WithTransport(rec))
+	for _, req := range goldenRequests {
+		srv.FindUsers(req)
+	}
+	if err := rec.Save(goldenFile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	srv := replayClient(t, replay.Faults{})
+
+	resp, err := srv.FindUsers(goldenRequests[0])
+	if err != nil || len(resp.Users) != 1 || resp.Users[0].Name != "Boyd Wolf" || resp.NextPage {
+		t.Errorf("unexpected response: %+v, %v", resp, err)
+	}
+	resp, err = srv.FindUsers(goldenRequests[1])
+	if err != nil || len(resp.Users) != 3 || !resp.NextPage || resp.Users[0].Age < resp.Users[2].Age {
+		t.Errorf("unexpected response: %+v, %v", resp, err)
+	}
+	resp, err = srv.FindUsers(goldenRequests[2])
+	if err != nil || len(resp.Users) == 0 {
+		t.Errorf("unexpected response: %+v, %v", resp, err)
+	}
+	for _, u := range resp.Users {
+		if u.Gender != "female" || u.Age < 30 {
+			t.Errorf("unexpected user: %+v", u)
+		}
+	}
+	if _, err = srv.FindUsers(goldenRequests[3]); !errors.Is(err, ErrBadOrderField) {
+		t.Errorf("expected ErrBadOrderField, got: %v", err)
+	}
+
+	if _, err = srv.FindUsers(SearchRequest{Limit: 1, Query: "not recorded"}); !errors.Is(err, replay.ErrNoInteraction) {
+		t.Errorf("expected ErrNoInteraction, got: %v", err)
+	}
+}
+
+func TestReplayFaults(t *testing.T) {
+	req := goldenRequests[0]
+
+	_, err := replayClient(t, replay.Faults{Latency: time.Second}, WithTimeout(10*time.Millisecond)).FindUsers(req)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("expected ErrTimeout, got: %v", err)
+	}
+
+	_, err = replayClient(t, replay.Faults{StatusCode: http.StatusBadGateway, Body: "bad gateway"}).FindUsers(req)
+	serverErr := &ServerError{}
+	if !errors.As(err, &serverErr) || serverErr.StatusCode != http.StatusBadGateway || serverErr.Body != "bad gateway" {
+		t.Errorf("expected ServerError, got: %v", err)
+	}
+
+	_, err = replayClient(t, replay.Faults{TruncateBody: 10}).FindUsers(req)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || !strings.HasPrefix(err.Error(), "cant read response body") {
+		t.Errorf("expected a read error, got: %v", err)
+	}
+
+	// the first attempt is refused, the retry gets the recorded response
+	srv := replayClient(t,
+		replay.Faults{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"1"}}, Times: 1},
+		WithRetry(RetryPolicy{MaxAttempts: 2, MaxDelay: time.Millisecond}),
+	)
+	if _, err = srv.FindUsers(req); !errors.Is(err, ErrServer) {
+		t.Errorf("Retry-After over MaxDelay must stop retries, got: %v", err)
+	}
+	srv = replayClient(t,
+		replay.Faults{StatusCode: http.StatusServiceUnavailable, Times: 1},
+		WithRetry(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}),
+	)
+	if resp, err := srv.FindUsers(req); err != nil || len(resp.Users) != 1 {
+		t.Errorf("expected the retry to succeed, got %+v, %v", resp, err)
+	}
+}
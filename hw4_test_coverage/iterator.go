@@ -0,0 +1,116 @@
+package main
+
+import "context"
+
+const maxPageSize = 25
+
+// UserIterator pages through the results of a search. The next page is
+// fetched in the background while the current one is consumed.
+//
+//	it := srv.Iterate(ctx, SearchRequest{Query: "Boyd"}, 0)
+//	defer it.Close()
+//	for it.Next() {
+//		user := it.User()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type UserIterator struct {
+	pages  chan page
+	cancel context.CancelFunc
+
+	users []User
+	user  User
+	err   error
+}
+
+type page struct {
+	users []User
+	err   error
+}
+
+// Iterate returns an iterator over all users matching req starting from
+// req.Offset, or req.Cursor if it is set. req.Limit sets the page size, 25
+// if 0 or larger. If max > 0 at most max users are returned.
+func (srv *SearchClient) Iterate(ctx context.Context, req SearchRequest, max int) *UserIterator {
+	ctx, cancel := context.WithCancel(ctx)
+	it := &UserIterator{
+		// one page is buffered, so the next one is requested right away
+		pages:  make(chan page, 1),
+		cancel: cancel,
+	}
+
+	pageSize := req.Limit
+	if pageSize <= 0 || pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	go func() {
+		defer cancel()
+		defer close(it.pages)
+
+		for fetched := 0; max <= 0 || fetched < max; {
+			req.Limit = pageSize
+			if max > 0 && max-fetched < pageSize {
+				req.Limit = max - fetched
+			}
+
+			resp, err := srv.FindUsersContext(ctx, req)
+			if err != nil {
+				select {
+				case it.pages <- page{err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+
+			select {
+			case it.pages <- page{users: resp.Users}:
+			case <-ctx.Done():
+				return
+			}
+
+			fetched += len(resp.Users)
+			req.Offset += len(resp.Users)
+			if req.Cursor != "" {
+				req.Cursor = resp.NextCursor
+			}
+			if !resp.NextPage || len(resp.Users) == 0 {
+				return
+			}
+		}
+	}()
+
+	return it
+}
+
+// Next advances to the next user, it returns false when there are no more
+// users or an error happened.
+func (it *UserIterator) Next() bool {
+	for len(it.users) == 0 {
+		if it.err != nil {
+			return false
+		}
+		p, ok := <-it.pages
+		if !ok {
+			return false
+		}
+		it.users, it.err = p.users, p.err
+	}
+
+	it.user, it.users = it.users[0], it.users[1:]
+	return true
+}
+
+func (it *UserIterator) User() User {
+	return it.user
+}
+
+// Err returns the error which stopped the iteration, if any.
+func (it *UserIterator) Err() error {
+	return it.err
+}
+
+// Close stops fetching pages, it is safe to call it more than once.
+func (it *UserIterator) Close() {
+	it.cancel()
+}
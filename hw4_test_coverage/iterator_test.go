@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// pagedServer serves users with ids from 0 to total-1 and fails with 500 on
+// offsets from failAt if it is > 0.
+func pagedServer(total, failAt int, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		limit, _ := strconv.Atoi(r.FormValue("limit"))
+		offset, _ := strconv.Atoi(r.FormValue("offset"))
+		if failAt > 0 && offset >= failAt {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+
+		users := []User{}
+		for id := offset; id < offset+limit && id < total; id++ {
+			users = append(users, User{Id: id})
+		}
+		json.NewEncoder(w).Encode(users)
+	}))
+}
+
+func collect(it *UserIterator) []int {
+	ids := []int{}
+	for it.Next() {
+		ids = append(ids, it.User().Id)
+	}
+	return ids
+}
+
+func TestIterate(t *testing.T) {
+	cases := []struct {
+		total, offset, limit, max int
+		expected, requests        int
+	}{
+		{total: 60, expected: 60, requests: 3},
+		{total: 50, expected: 50, requests: 2},
+		{total: 60, limit: 10, expected: 60, requests: 6},
+		{total: 60, offset: 55, expected: 5, requests: 1},
+		{total: 60, max: 30, expected: 30, requests: 2},
+		{total: 60, limit: 7, max: 20, expected: 20, requests: 3},
+		{total: 0, expected: 0, requests: 1},
+	}
+
+	for _, c := range cases {
+		var requests int32
+		ts := pagedServer(c.total, 0, &requests)
+		srv := NewSearchClient(ts.URL, AccessToken)
+
+		it := srv.Iterate(context.Background(), SearchRequest{Limit: c.limit, Offset: c.offset}, c.max)
+		ids := collect(it)
+		it.Close()
+		ts.Close()
+
+		if it.Err() != nil {
+			t.Errorf("%+v: unexpected error: %s", c, it.Err())
+		}
+		if len(ids) != c.expected {
+			t.Errorf("%+v: got %d users, expected %d", c, len(ids), c.expected)
+		}
+		for i, id := range ids {
+			if id != c.offset+i {
+				t.Errorf("%+v: user %d has id %d", c, i, id)
+				break
+			}
+		}
+		if int(requests) != c.requests {
+			t.Errorf("%+v: got %d requests, expected %d", c, requests, c.requests)
+		}
+	}
+}
+
+func TestIterateError(t *testing.T) {
+	var requests int32
+	ts := pagedServer(100, 50, &requests)
+	defer ts.Close()
+
+	it := NewSearchClient(ts.URL, AccessToken).Iterate(context.Background(), SearchRequest{}, 0)
+	defer it.Close()
+
+	ids := collect(it)
+	if len(ids) != 50 {
+		t.Errorf("got %d users before the error, expected 50", len(ids))
+	}
+	if !errors.Is(it.Err(), ErrServer) {
+		t.Errorf("expected ErrServer, got: %v", it.Err())
+	}
+	if it.Next() {
+		t.Error("Next after an error must return false")
+	}
+}
+
+func TestIterateClose(t *testing.T) {
+	var requests int32
+	ts := pagedServer(1000, 0, &requests)
+	defer ts.Close()
+
+	it := NewSearchClient(ts.URL, AccessToken).Iterate(context.Background(), SearchRequest{}, 0)
+	if !it.Next() {
+		t.Fatal("expected a user")
+	}
+	it.Close()
+	it.Close()
+
+	for it.Next() {
+	}
+	// the current page, the prefetched one and at most one in flight
+	if n := atomic.LoadInt32(&requests); n > 3 {
+		t.Errorf("got %d requests after Close, expected at most 3", n)
+	}
+}
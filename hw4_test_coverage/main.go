@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+var orderNames = map[string]int{
+	"asc":  OrderByAsc,
+	"desc": OrderByDesc,
+	"asis": OrderByAsIs,
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout, os.Stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		// -h asked for the usage, it is already printed
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run searches users as the command line asks and prints them to out,
+// notes for the user go to errOut.
+func run(args []string, out, errOut io.Writer) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	serverURL := flags.String("url", "http://localhost:8080/", "search server")
+	token := flags.String("token", os.Getenv("SEARCH_ACCESS_TOKEN"), "access token, $SEARCH_ACCESS_TOKEN by default")
+	query := flags.String("query", "", "substring of Name or About, words to rank with -order-field Relevance")
+	orderField := flags.String("order-field", "", "Id, Age, Name or Relevance (default Name)")
+	orderBy := flags.String("order-by", "asis", "asc, desc or asis")
+	limit := flags.Int("limit", 10, "number of users, at most 25 per request")
+	offset := flags.Int("offset", 0, "number of users to skip")
+	all := flags.Bool("all", false, "page through all users from -offset, -limit is the page size then")
+	format := flags.String("format", formatTable, "output format: table or json")
+	timeout := flags.Duration("timeout", 5*time.Second, "timeout of a request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	order, ok := orderNames[*orderBy]
+	if !ok {
+		return fmt.Errorf("unknown order %q, use asc, desc or asis", *orderBy)
+	}
+	if *format != formatTable && *format != formatJSON {
+		return fmt.Errorf("unknown format %q, use table or json", *format)
+	}
+
+	srv := NewSearchClient(*serverURL, *token, WithTimeout(*timeout))
+	req := SearchRequest{
+		Limit:      *limit,
+		Offset:     *offset,
+		Query:      *query,
+		OrderField: *orderField,
+		OrderBy:    order,
+	}
+
+	var users []User
+	if *all {
+		it := srv.Iterate(context.Background(), req, 0)
+		defer it.Close()
+		for it.Next() {
+			users = append(users, it.User())
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	} else {
+		resp, err := srv.FindUsers(req)
+		if err != nil {
+			return err
+		}
+		users = resp.Users
+		if resp.NextPage {
+			fmt.Fprintf(errOut, "more users from -offset %d\n", req.Offset+len(users))
+		}
+	}
+
+	return printUsers(out, users, *format)
+}
+
+func printUsers(out io.Writer, users []User, format string) error {
+	if format == formatJSON {
+		if users == nil {
+			users = []User{}
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(users)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tAGE\tGENDER\tABOUT")
+	for _, u := range users {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\n", u.Id, u.Name, u.Age, u.Gender, shorten(u.About, 40))
+	}
+	return w.Flush()
+}
+
+// shorten cuts text to n runes on one line.
+func shorten(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-3]) + "..."
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	ts := newSearchServer(t)
+	common := []string{"-url", ts.URL, "-token", AccessToken}
+
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	args := append(common, "-query", "Boyd", "-order-field", "Age", "-order-by", "desc")
+	if err := run(args, out, errOut); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID ") || !strings.HasPrefix(lines[1], "0   Boyd Wolf  22") || errOut.Len() != 0 {
+		t.Errorf("unexpected table:\n%s%s", out, errOut)
+	}
+
+	out.Reset()
+	if err := run(append(common, "-limit", "3", "-offset", "1", "-format", "json"), out, errOut); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	found := []User{}
+	if err := json.Unmarshal(out.Bytes(), &found); err != nil || len(found) != 3 || found[0].Id != 1 {
+		t.Errorf("unexpected json %s: %v", out, err)
+	}
+	if errOut.String() != "more users from -offset 4\n" {
+		t.Errorf("unexpected note: %q", errOut)
+	}
+
+	out.Reset()
+	if err := run(append(common, "-all", "-limit", "7", "-offset", "5", "-format", "json"), out, errOut); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if err := json.Unmarshal(out.Bytes(), &found); err != nil || len(found) != 30 || found[29].Id != 34 {
+		t.Errorf("expected 30 users, got %d: %v", len(found), err)
+	}
+
+	out.Reset()
+	if err := run(append(common, "-query", "nobody", "-format", "json"), out, errOut); err != nil || out.String() != "[]\n" {
+		t.Errorf("expected an empty list, got %q, %v", out, err)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	ts := newSearchServer(t)
+
+	for _, args := range [][]string{
+		{"-order-by", "up"},
+		{"-format", "xml"},
+		{"-limit", "x"},
+		{"-url", ts.URL, "-token", "wrong"},
+		{"-url", ts.URL, "-token", "wrong", "-all"},
+		{"-url", ts.URL, "-token", AccessToken, "-order-field", "About"},
+	} {
+		if err := run(args, &bytes.Buffer{}, &bytes.Buffer{}); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+func TestRunHelp(t *testing.T) {
+	if os.Getenv("SEARCH_TEST_MAIN") == "1" {
+		os.Args = []string{"search", "-h"}
+		main()
+		return
+	}
+
+	errOut := &bytes.Buffer{}
+	if err := run([]string{"-h"}, &bytes.Buffer{}, errOut); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("expected flag.ErrHelp, got %v", err)
+	}
+	if !strings.Contains(errOut.String(), "-order-field") {
+		t.Errorf("expected the usage, got %q", errOut)
+	}
+
+	// main exits with 0 and prints the usage only
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRunHelp$")
+	cmd.Env = append(os.Environ(), "SEARCH_TEST_MAIN=1")
+	errOut.Reset()
+	cmd.Stderr = errOut
+	if err := cmd.Run(); err != nil || strings.Contains(errOut.String(), "help requested") {
+		t.Errorf("-h: %v\n%s", err, errOut)
+	}
+}
+
+func TestShorten(t *testing.T) {
+	cases := map[string]string{
+		"short":      "short",
+		"two\nlines": "two lines",
+		"Тексты длиннее лимита": "Тексты д...",
+	}
+	for text, expected := range cases {
+		if got := shorten(text, 11); got != expected {
+			t.Errorf("%q: got %q, expected %q", text, got, expected)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Faults describes what goes wrong with requests, zero values change
+// nothing.
+type Faults struct {
+	// Latency delays every response, the request context still aborts it
+	Latency time.Duration
+	// StatusCode replaces responses with this status and Body
+	StatusCode int
+	Body       string
+	Header     http.Header
+	// TruncateBody cuts response bodies to this many bytes, reading further
+	// fails with io.ErrUnexpectedEOF
+	TruncateBody int
+	// Err fails requests with this error instead of sending them
+	Err error
+	// Times limits the faults to the first requests, 0 means all of them
+	Times int
+}
+
+// Inject returns a transport which passes requests to next and applies
+// faults to them.
+func Inject(next http.RoundTripper, faults Faults) http.RoundTripper {
+	return &faulty{next: next, faults: faults}
+}
+
+type faulty struct {
+	next   http.RoundTripper
+	faults Faults
+
+	mu   sync.Mutex
+	sent int
+}
+
+func (f *faulty) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	f.sent++
+	active := f.faults.Times <= 0 || f.sent <= f.faults.Times
+	f.mu.Unlock()
+	if !active {
+		return f.next.RoundTrip(r)
+	}
+
+	if f.faults.Latency > 0 {
+		timer := time.NewTimer(f.faults.Latency)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			return nil, r.Context().Err()
+		}
+	}
+	if f.faults.Err != nil {
+		return nil, f.faults.Err
+	}
+
+	var resp *http.Response
+	if f.faults.StatusCode != 0 {
+		header := f.faults.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		resp = &http.Response{
+			Status:     fmt.Sprintf("%d %s", f.faults.StatusCode, http.StatusText(f.faults.StatusCode)),
+			StatusCode: f.faults.StatusCode,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     header,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(f.faults.Body))),
+			Request:    r,
+		}
+	} else {
+		var err error
+		if resp, err = f.next.RoundTrip(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.faults.TruncateBody > 0 {
+		resp.Body = &truncatedBody{body: resp.Body, left: f.faults.TruncateBody}
+		resp.ContentLength = -1
+	}
+	return resp, nil
+}
+
+// truncatedBody fails with io.ErrUnexpectedEOF after left bytes, bodies
+// which are shorter are read as they are.
+type truncatedBody struct {
+	body io.ReadCloser
+	left int
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.left == 0 {
+		if n, _ := b.body.Read(make([]byte, 1)); n > 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, io.EOF
+	}
+	if len(p) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.body.Read(p)
+	b.left -= n
+	return n, err
+}
+
+func (b *truncatedBody) Close() error {
+	return b.body.Close()
+}
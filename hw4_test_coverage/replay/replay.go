@@ -0,0 +1,171 @@
+// Package replay records HTTP interactions to golden files and replays
+// them, so tests of SearchClient don't need a server of their own. Inject
+// adds faults to any transport.
+//
+//	rec := replay.Record(http.DefaultTransport)
+//	... send requests through rec ...
+//	err := rec.Save("testdata/replay/search.json")
+//
+//	rep, err := replay.Load("testdata/replay/search.json")
+//	client := &http.Client{Transport: replay.Inject(rep, replay.Faults{StatusCode: 500})}
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrNoInteraction is returned by Replayer for requests which weren't
+// recorded, or were replayed as many times as recorded.
+var ErrNoInteraction = errors.New("replay: no recorded interaction")
+
+// Interaction is a request and its response as kept in a golden file.
+// Request headers aren't kept, they may hold tokens.
+type Interaction struct {
+	Method   string
+	URL      string
+	Response Response
+}
+
+type Response struct {
+	StatusCode int
+	Header     http.Header `json:",omitempty"`
+	Body       string
+}
+
+// key identifies a request regardless of the host and the order of the
+// query parameters.
+func key(r *http.Request) string {
+	return r.Method + " " + r.URL.Path + "?" + r.URL.Query().Encode()
+}
+
+// Recorder is a transport which passes requests to the next one and keeps
+// the interactions.
+type Recorder struct {
+	next http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+func Record(next http.RoundTripper) *Recorder {
+	return &Recorder{next: next}
+}
+
+func (rec *Recorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := rec.next.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	header := resp.Header.Clone()
+	// they change with every response or follow from the body
+	header.Del("Date")
+	header.Del("Content-Length")
+	if len(header) == 0 {
+		header = nil
+	}
+	rec.mu.Lock()
+	rec.interactions = append(rec.interactions, Interaction{
+		Method:   r.Method,
+		URL:      r.URL.Path + "?" + r.URL.Query().Encode(),
+		Response: Response{StatusCode: resp.StatusCode, Header: header, Body: string(body)},
+	})
+	rec.mu.Unlock()
+	return resp, nil
+}
+
+// Save writes the recorded interactions to a golden file, creating its
+// directory if needed.
+func (rec *Recorder) Save(path string) error {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	rec.mu.Lock()
+	err := enc.Encode(rec.interactions)
+	rec.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// Replayer is a transport answering with recorded responses. Identical
+// requests get their responses in the recorded order.
+type Replayer struct {
+	mu        sync.Mutex
+	responses map[string][]Response
+}
+
+// Load reads a golden file written by Recorder.Save.
+func Load(path string) (*Replayer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	interactions := []Interaction{}
+	if err := json.Unmarshal(data, &interactions); err != nil {
+		return nil, fmt.Errorf("replay: %s: %w", path, err)
+	}
+
+	rep := &Replayer{responses: make(map[string][]Response)}
+	for _, i := range interactions {
+		u, err := url.Parse(i.URL)
+		if err != nil {
+			return nil, fmt.Errorf("replay: %s: %w", path, err)
+		}
+		k := key(&http.Request{Method: i.Method, URL: u})
+		rep.responses[k] = append(rep.responses[k], i.Response)
+	}
+	return rep, nil
+}
+
+func (rep *Replayer) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Body != nil {
+		r.Body.Close()
+	}
+	k := key(r)
+	rep.mu.Lock()
+	responses := rep.responses[k]
+	if len(responses) == 0 {
+		rep.mu.Unlock()
+		return nil, fmt.Errorf("%w for %s", ErrNoInteraction, k)
+	}
+	resp := responses[0]
+	rep.responses[k] = responses[1:]
+	rep.mu.Unlock()
+
+	header := resp.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+		StatusCode:    resp.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(resp.Body))),
+		ContentLength: int64(len(resp.Body)),
+		Request:       r,
+	}, nil
+}
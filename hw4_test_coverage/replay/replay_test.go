@@ -0,0 +1,141 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, client *http.Client, url string) (*http.Response, string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp, string(body), err
+}
+
+func TestRecordReplay(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("ETag", `"x"`)
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "fail", http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, r.URL.Query().Get("a")+r.URL.Query().Get("b")+string(rune('0'+calls)))
+	}))
+	defer ts.Close()
+
+	rec := Record(http.DefaultTransport)
+	client := &http.Client{Transport: rec}
+	for _, query := range []string{"?a=1&b=2", "?a=1&b=2", "?fail=1"} {
+		if _, _, err := get(t, client, ts.URL+"/search"+query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "golden", "search.json")
+	if err := rec.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: rep}
+	// another host and order of parameters
+	for _, expected := range []string{"121", "122"} {
+		resp, body, err := get(t, client, "http://replay.test/search?b=2&a=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != expected || resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != `"x"` || resp.Header.Get("Date") != "" {
+			t.Errorf("got %d %q %v, expected %q", resp.StatusCode, body, resp.Header, expected)
+		}
+	}
+	resp, body, err := get(t, client, "http://replay.test/search?fail=1")
+	if err != nil || resp.StatusCode != http.StatusInternalServerError || body != "fail\n" {
+		t.Errorf("unexpected response: %v %q %v", resp, body, err)
+	}
+
+	_, _, err = get(t, client, "http://replay.test/search?a=1&b=2")
+	if !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("expected ErrNoInteraction, got: %v", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected error for a missing file")
+	}
+	path := filepath.Join(t.TempDir(), "bad.json")
+	ioutil.WriteFile(path, []byte("{"), 0644)
+	if _, err := Load(path); err == nil {
+		t.Error("expected error for bad json")
+	}
+	ioutil.WriteFile(path, []byte(`[{"Method": "GET", "URL": "%zz"}]`), 0644)
+	if _, err := Load(path); err == nil {
+		t.Error("expected error for bad url")
+	}
+}
+
+func TestInject(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "0123456789")
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: Inject(http.DefaultTransport, Faults{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": {"1"}},
+		Body:       "slow down",
+		Times:      1,
+	})}
+	resp, body, err := get(t, client, ts.URL)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests || body != "slow down" || resp.Header.Get("Retry-After") != "1" {
+		t.Errorf("unexpected response: %v %q %v", resp, body, err)
+	}
+	resp, body, err = get(t, client, ts.URL)
+	if err != nil || resp.StatusCode != http.StatusOK || body != "0123456789" {
+		t.Errorf("faults must stop after Times: %v %q %v", resp, body, err)
+	}
+
+	client = &http.Client{Transport: Inject(http.DefaultTransport, Faults{TruncateBody: 4})}
+	_, body, err = get(t, client, ts.URL)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || body != "0123" {
+		t.Errorf("expected a truncated body, got %q %v", body, err)
+	}
+	client = &http.Client{Transport: Inject(http.DefaultTransport, Faults{TruncateBody: 10})}
+	if _, body, err = get(t, client, ts.URL); err != nil || body != "0123456789" {
+		t.Errorf("expected the whole body, got %q %v", body, err)
+	}
+
+	errFault := errors.New("connection reset")
+	client = &http.Client{Transport: Inject(http.DefaultTransport, Faults{Err: errFault})}
+	if _, _, err = get(t, client, ts.URL); !errors.Is(err, errFault) {
+		t.Errorf("expected %v, got %v", errFault, err)
+	}
+
+	client = &http.Client{Transport: Inject(http.DefaultTransport, Faults{Latency: 20 * time.Millisecond})}
+	start := time.Now()
+	if _, _, err = get(t, client, ts.URL); err != nil || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("expected latency, got %v after %v", err, time.Since(start))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r, _ := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+	client = &http.Client{Transport: Inject(http.DefaultTransport, Faults{Latency: time.Hour})}
+	if _, err = client.Do(r); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+}
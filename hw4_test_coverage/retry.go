@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryPolicy repeats searches failed with transient errors. A search only
+// reads data, so repeating it is safe.
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt too, 1 or less disables retries
+	MaxAttempts int
+	// the n-th retry waits BaseDelay*2^(n-1), at most MaxDelay, and half of
+	// the delay is random
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Retryable reports whether a failed search may be repeated,
+	// IsTransient if nil
+	Retryable func(error) bool
+}
+
+// IsTransient reports whether err is a timeout, a failure of the server or
+// a network error.
+func IsTransient(err error) bool {
+	opErr := &net.OpError{}
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrServer) || errors.As(err, &opErr)
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsTransient(err)
+}
+
+// delay returns the pause before the given retry, counting from 1, and
+// false if the server asks to wait longer than MaxDelay.
+func (p *RetryPolicy) delay(retry int, err error) (time.Duration, bool) {
+	serverErr := &ServerError{}
+	if errors.As(err, &serverErr) && serverErr.RetryAfter > 0 {
+		return serverErr.RetryAfter, p.MaxDelay <= 0 || serverErr.RetryAfter <= p.MaxDelay
+	}
+
+	d := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0, true
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1)), true
+}
+
+// parseRetryAfter reads the Retry-After header given either in seconds or
+// as a date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+func WithRetry(policy RetryPolicy) Option {
+	return func(cfg *clientConfig) {
+		cfg.retry = &policy
+	}
+}
+
+// WithCircuitBreaker makes the client fail fast with ErrCircuitOpen for
+// cooldown after threshold transient errors in a row. Then a single trial
+// request is let through, it closes the breaker if it succeeds.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(cfg *clientConfig) {
+		cfg.breaker = &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+	}
+}
+
+func (srv *SearchClient) withRetries(ctx context.Context, search func() (*SearchResponse, error)) (*SearchResponse, error) {
+	for attempt := 1; ; attempt++ {
+		if err := srv.breaker.allow(); err != nil {
+			return nil, err
+		}
+		resp, err := search()
+		srv.breaker.record(err)
+
+		if err == nil || srv.retry == nil || attempt >= srv.retry.MaxAttempts || !srv.retry.retryable(err) {
+			return resp, err
+		}
+
+		delay, ok := srv.retry.delay(attempt, err)
+		if !ok {
+			return nil, err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker counts transient errors in a row, a nil breaker lets every
+// request through.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		// the trial request is still running
+		return ErrCircuitOpen
+	default:
+		return nil
+	}
+}
+
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		// says nothing about the server, let the next request be the trial
+		if b.state == breakerHalfOpen {
+			b.state = breakerOpen
+		}
+		return
+	}
+	if err == nil || !IsTransient(err) {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
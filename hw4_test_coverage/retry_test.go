@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer answers with status to the first failures requests and with
+// an empty result to the rest.
+func flakyServer(failures int32, status int, header http.Header, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(requests, 1) <= failures {
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			http.Error(w, "try later", status)
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+}
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	cases := []struct {
+		failures int32
+		status   int
+		header   http.Header
+		err      error
+		requests int32
+	}{
+		{failures: 2, status: http.StatusInternalServerError, requests: 3},
+		{failures: 2, status: http.StatusServiceUnavailable, requests: 3},
+		{failures: 3, status: http.StatusBadGateway, err: ErrServer, requests: 3},
+		{failures: 1, status: http.StatusUnauthorized, err: ErrUnauthorized, requests: 1},
+		{failures: 1, status: http.StatusTooManyRequests, header: http.Header{"Retry-After": {"120"}}, err: ErrServer, requests: 1},
+	}
+
+	for _, c := range cases {
+		var requests int32
+		ts := flakyServer(c.failures, c.status, c.header, &requests)
+		srv := NewSearchClient(ts.URL, AccessToken, WithRetry(policy))
+
+		_, err := srv.FindUsers(defaultReq)
+		ts.Close()
+		if !errors.Is(err, c.err) {
+			t.Errorf("%d x %d: got error %v, expected %v", c.failures, c.status, err, c.err)
+		}
+		if requests != c.requests {
+			t.Errorf("%d x %d: got %d requests, expected %d", c.failures, c.status, requests, c.requests)
+		}
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	var requests int32
+	ts := flakyServer(10, http.StatusInternalServerError, nil, &requests)
+	defer ts.Close()
+
+	srv := NewSearchClient(ts.URL, AccessToken, WithRetry(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour}))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := srv.FindUsersContext(ctx, defaultReq)
+	if err != context.DeadlineExceeded || requests != 1 {
+		t.Errorf("got error %v after %d requests, expected %v after 1", err, requests, context.DeadlineExceeded)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+	for retry, max := range []time.Duration{10, 20, 40, 50, 50} {
+		max *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			d, ok := policy.delay(retry+1, ErrServer)
+			if !ok || d < max/2 || d > max {
+				t.Fatalf("retry %d: got %s, expected between %s and %s", retry+1, d, max/2, max)
+			}
+		}
+	}
+
+	if d, ok := policy.delay(1, &ServerError{RetryAfter: 30 * time.Millisecond}); !ok || d != 30*time.Millisecond {
+		t.Errorf("Retry-After: got %s, %v", d, ok)
+	}
+	if _, ok := policy.delay(1, &ServerError{RetryAfter: time.Second}); ok {
+		t.Error("Retry-After longer than MaxDelay must stop retries")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := map[string]time.Duration{
+		"":                              0,
+		"3":                             3 * time.Second,
+		"-1":                            0,
+		"soon":                          0,
+		"Wed, 01 Jan 2020 00:01:00 GMT": time.Minute,
+		"Tue, 31 Dec 2019 23:00:00 GMT": 0,
+	}
+
+	for value, expected := range cases {
+		if got := parseRetryAfter(value, now); got != expected {
+			t.Errorf("%q: got %s, expected %s", value, got, expected)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var requests int32
+	ts := flakyServer(3, http.StatusInternalServerError, nil, &requests)
+	defer ts.Close()
+
+	now := time.Now()
+	srv := NewSearchClient(ts.URL, AccessToken, WithCircuitBreaker(2, time.Minute))
+	srv.breaker.now = func() time.Time { return now }
+
+	steps := []struct {
+		advance  time.Duration
+		err      error
+		requests int32
+	}{
+		{err: ErrServer, requests: 1},
+		{err: ErrServer, requests: 2}, // opens the breaker
+		{err: ErrCircuitOpen, requests: 2},
+		{advance: 30 * time.Second, err: ErrCircuitOpen, requests: 2},
+		{advance: 31 * time.Second, err: ErrServer, requests: 3}, // the trial fails
+		{err: ErrCircuitOpen, requests: 3},
+		{advance: time.Minute, requests: 4}, // the trial succeeds
+		{requests: 5},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.advance)
+		_, err := srv.FindUsers(defaultReq)
+		if !errors.Is(err, step.err) {
+			t.Errorf("step %d: got error %v, expected %v", i, err, step.err)
+		}
+		if requests != step.requests {
+			t.Errorf("step %d: got %d requests, expected %d", i, requests, step.requests)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"hash/fnv"
+	"net/url"
+	"sort"
+)
+
+// CursorStart as the cursor parameter asks for the first page of cursor
+// pagination.
+const CursorStart = "*"
+
+// position holds the sort values of a user, a cursor keeps the position of
+// the last user returned. The next page starts after it even if that user
+// is gone by then.
+type position struct {
+	Id    int     `json:"i"`
+	Age   int     `json:"a,omitempty"`
+	Name  string  `json:"n,omitempty"`
+	Score float64 `json:"s,omitempty"`
+}
+
+func positionOf(u *User, scores map[int]float64) position {
+	return position{Id: u.Id, Age: u.Age, Name: u.Name, Score: scores[u.Id]}
+}
+
+func (p position) user() *User {
+	return &User{Id: p.Id, Age: p.Age, Name: p.Name}
+}
+
+type cursor struct {
+	// Search is the hash of the search parameters, a cursor is only valid
+	// for the search it was returned by
+	Search uint64   `json:"q"`
+	After  position `json:"p"`
+}
+
+// searchHash identifies the search regardless of the page.
+func searchHash(params url.Values) uint64 {
+	search := url.Values{}
+	for key, values := range params {
+		switch key {
+		case "limit", "offset", "cursor":
+		default:
+			search[key] = values
+		}
+	}
+	return fnv64([]byte(search.Encode()))
+}
+
+func fnv64(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (cursor, bool) {
+	c := cursor{}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return c, false
+	}
+	return c, json.Unmarshal(data, &c) == nil
+}
+
+// cursorOrder is the order of users for cursor pagination. Unlike the
+// offset mode it is total, ties are broken by Id, and users go by Id when
+// no order is given.
+func cursorOrder(keys []sortKey, relevance bool, less func(a, b *User) bool, orderBy int) func(a, b position) bool {
+	return func(a, b position) bool {
+		ua, ub := a.user(), b.user()
+		switch {
+		case len(keys) > 0:
+			if c := compareKeys(keys, ua, ub); c != 0 {
+				return c < 0
+			}
+		case relevance:
+			if a.Score != b.Score {
+				return a.Score > b.Score
+			}
+		case orderBy == OrderByAsc && less(ua, ub) || orderBy == OrderByDesc && less(ub, ua):
+			return true
+		case orderBy == OrderByAsc && less(ub, ua) || orderBy == OrderByDesc && less(ua, ub):
+			return false
+		}
+		return a.Id < b.Id
+	}
+}
+
+// pageAfter sorts users, drops the ones up to the cursor and returns at most
+// limit of them, with the cursor of the next page if there are more.
+func pageAfter(users []User, scores map[int]float64, before func(a, b position) bool, c *cursor, limit int, search uint64) ([]User, string) {
+	sort.Slice(users, func(i, j int) bool {
+		return before(positionOf(&users[i], scores), positionOf(&users[j], scores))
+	})
+	if c != nil {
+		start := sort.Search(len(users), func(i int) bool {
+			return before(c.After, positionOf(&users[i], scores))
+		})
+		users = users[start:]
+	}
+	if limit >= len(users) {
+		return users, ""
+	}
+	users = users[:limit]
+	return users, cursor{Search: search, After: positionOf(&users[limit-1], scores)}.encode()
+}
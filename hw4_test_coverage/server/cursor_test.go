@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// pages collects all users page by page following the cursors.
+func pages(t *testing.T, srv *SearchServer, params url.Values, limit string) []int {
+	result := []int{}
+	params.Set("limit", limit)
+	params.Set("offset", "0")
+	params.Set("cursor", CursorStart)
+	for i := 0; i < 100; i++ {
+		w, users := search(t, srv, params, token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: got status %d", params, w.Code)
+		}
+		result = append(result, ids(users)...)
+		next := w.Header().Get(CursorHeader)
+		if next == "" {
+			return result
+		}
+		params.Set("cursor", next)
+	}
+	t.Fatalf("%v: too many pages", params)
+	return nil
+}
+
+func TestSearchCursor(t *testing.T) {
+	srv := New(loadDataset(t), token)
+
+	cases := []url.Values{
+		{},
+		{"order_field": {"Age"}, "order_by": {"-1"}},
+		{"order_field": {"Name"}, "order_by": {"1"}},
+		{"sort": {"Age:desc,Name"}},
+		{"gender": {"female"}, "order_field": {"Age"}, "order_by": {"1"}},
+		{"query": {"nulla"}, "order_field": {"Id"}, "order_by": {"1"}},
+		{"query": {"velit nulla"}, "order_field": {OrderByRelevance}},
+	}
+
+	for _, params := range cases {
+		all := url.Values{}
+		for key, values := range params {
+			all[key] = values
+		}
+		expected := pages(t, srv, all, "100")
+		for _, limit := range []string{"1", "4", "35"} {
+			got := pages(t, srv, params, limit)
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("%v: got %v, expected %v", params, got, expected)
+			}
+		}
+	}
+}
+
+func TestSearchCursorOrder(t *testing.T) {
+	srv := New(loadDataset(t), token)
+
+	// ties are broken by Id
+	got := pages(t, srv, url.Values{"order_field": {"Age"}, "order_by": {"1"}}, "5")
+	users := srv.users
+	byId := make(map[int]User, len(users))
+	for _, u := range users {
+		byId[u.Id] = u
+	}
+	for i := 1; i < len(got); i++ {
+		a, b := byId[got[i-1]], byId[got[i]]
+		if a.Age < b.Age || a.Age == b.Age && a.Id > b.Id {
+			t.Errorf("users %d and %d are out of order", a.Id, b.Id)
+		}
+	}
+}
+
+func TestSearchCursorDataChange(t *testing.T) {
+	users := loadDataset(t)
+	params := url.Values{"limit": {"3"}, "offset": {"0"}, "cursor": {CursorStart}, "order_field": {"Id"}, "order_by": {"-1"}}
+	w, page := search(t, New(users, token), params, token)
+	if got := ids(page); !reflect.DeepEqual(got, []int{0, 1, 2}) {
+		t.Fatalf("got %v", got)
+	}
+
+	// the last user of the page and the first one of the next are gone
+	changed := append(append([]User{}, users[:2]...), users[4:]...)
+	params.Set("cursor", w.Header().Get(CursorHeader))
+	_, page = search(t, New(changed, token), params, token)
+	if got := ids(page); !reflect.DeepEqual(got, []int{4, 5, 6}) {
+		t.Errorf("got %v, expected [4 5 6]", got)
+	}
+}
+
+func TestSearchCursorErrors(t *testing.T) {
+	srv := New(loadDataset(t), token)
+
+	w, _ := search(t, srv, url.Values{"limit": {"1"}, "offset": {"0"}, "cursor": {CursorStart}, "query": {"nulla"}}, token)
+	other := w.Header().Get(CursorHeader)
+	w, _ = search(t, srv, url.Values{"limit": {"1"}, "offset": {"0"}, "cursor": {CursorStart}}, token)
+	next := w.Header().Get(CursorHeader)
+
+	for _, cursor := range []string{"!", "bm90IGpzb24", other, next + "x"} {
+		w, _ := search(t, srv, url.Values{"limit": {"1"}, "offset": {"0"}, "cursor": {cursor}}, token)
+		resp := errorResponse{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusBadRequest || resp.Error != ErrorBadCursor {
+			t.Errorf("%q: got status %d %+v", cursor, w.Code, resp)
+		}
+	}
+}
+
+func TestSearchCursorETag(t *testing.T) {
+	users := loadDataset(t)
+	params := url.Values{"limit": {"100"}, "offset": {"0"}, "cursor": {CursorStart}, "min_age": {"40"}}
+	_, page := search(t, New(users, token), params, token)
+	// the page holds all of them exactly
+	params.Set("limit", strconv.Itoa(len(page)))
+	w, page := search(t, New(users, token), params, token)
+	if len(page) == 0 || w.Header().Get(CursorHeader) != "" {
+		t.Fatalf("expected the last page, got %v", ids(page))
+	}
+
+	// a user added after the page, the body is the same but there is a next page
+	added := append(append([]User{}, users...), User{Id: 100, Name: "Zed", Age: 99})
+	r := httptest.NewRequest("GET", "/?"+params.Encode(), nil)
+	r.Header.Set(TokenHeader, token)
+	r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	New(added, token).ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get(CursorHeader) == "" {
+		t.Errorf("expected a new response with the next cursor, got %d", w.Code)
+	}
+}
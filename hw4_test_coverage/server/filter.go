@@ -0,0 +1,125 @@
+package server
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// filter narrows search results down by user attributes, zero values
+// don't filter.
+type filter struct {
+	minAge, maxAge int
+	gender         string
+	ids            map[int]bool
+}
+
+var genders = map[string]bool{"male": true, "female": true}
+
+// parseFilter reads min_age, max_age, gender and ids=1,2,3 parameters and
+// returns the name of the first invalid one.
+func parseFilter(params url.Values) (f filter, invalid string) {
+	for _, bound := range []struct {
+		name  string
+		value *int
+	}{
+		{"min_age", &f.minAge},
+		{"max_age", &f.maxAge},
+	} {
+		if value := params.Get(bound.name); value != "" {
+			age, err := strconv.Atoi(value)
+			if err != nil || age < 0 {
+				return f, bound.name
+			}
+			*bound.value = age
+		}
+	}
+	if f.maxAge > 0 && f.minAge > f.maxAge {
+		return f, "max_age"
+	}
+
+	f.gender = params.Get("gender")
+	if f.gender != "" && !genders[f.gender] {
+		return f, "gender"
+	}
+
+	if ids := params.Get("ids"); ids != "" {
+		f.ids = make(map[int]bool)
+		for _, value := range strings.Split(ids, ",") {
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return f, "ids"
+			}
+			f.ids[id] = true
+		}
+	}
+	return f, ""
+}
+
+func (f filter) match(u *User) bool {
+	return u.Age >= f.minAge &&
+		(f.maxAge == 0 || u.Age <= f.maxAge) &&
+		(f.gender == "" || u.Gender == f.gender) &&
+		(f.ids == nil || f.ids[u.Id])
+}
+
+// apply keeps the matching users in place.
+func (f filter) apply(users []User) []User {
+	result := users[:0]
+	for i := range users {
+		if f.match(&users[i]) {
+			result = append(result, users[i])
+		}
+	}
+	return result
+}
+
+type sortKey struct {
+	less func(a, b *User) bool
+	desc bool
+}
+
+// parseSort reads the sort parameter like "Age:desc,Name" where the order
+// is asc if omitted. If a field is invalid it is returned with ok false.
+func parseSort(value string) (keys []sortKey, invalid string, ok bool) {
+	if value == "" {
+		return nil, "", true
+	}
+	for _, item := range strings.Split(value, ",") {
+		field, order := item, "asc"
+		if colon := strings.IndexByte(item, ':'); colon >= 0 {
+			field, order = item[:colon], item[colon+1:]
+		}
+		less, ok := orderFields[field]
+		if !ok || field == "" || order != "asc" && order != "desc" {
+			return nil, field, false
+		}
+		keys = append(keys, sortKey{less: less, desc: order == "desc"})
+	}
+	return keys, "", true
+}
+
+func sortBy(users []User, keys []sortKey) {
+	sort.SliceStable(users, func(i, j int) bool {
+		return compareKeys(keys, &users[i], &users[j]) < 0
+	})
+}
+
+// compareKeys returns -1 if a goes before b, 1 if after and 0 if keys
+// don't tell them apart.
+func compareKeys(keys []sortKey, a, b *User) int {
+	for _, key := range keys {
+		x, y := a, b
+		if key.desc {
+			x, y = b, a
+		}
+		if key.less(x, y) {
+			return -1
+		}
+		if key.less(y, x) {
+			return 1
+		}
+	}
+	return 0
+}
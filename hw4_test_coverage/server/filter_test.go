@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestSearchFilters(t *testing.T) {
+	srv := New(loadDataset(t), token)
+
+	cases := []struct {
+		params url.Values
+		match  func(u *User) bool
+	}{
+		{url.Values{"min_age": {"30"}}, func(u *User) bool { return u.Age >= 30 }},
+		{url.Values{"max_age": {"25"}}, func(u *User) bool { return u.Age <= 25 }},
+		{url.Values{"min_age": {"25"}, "max_age": {"30"}}, func(u *User) bool { return u.Age >= 25 && u.Age <= 30 }},
+		{url.Values{"gender": {"female"}}, func(u *User) bool { return u.Gender == "female" }},
+		{url.Values{"ids": {"3,1,20"}}, func(u *User) bool { return u.Id == 1 || u.Id == 3 || u.Id == 20 }},
+		{url.Values{"gender": {"male"}, "ids": {"0,1,2,3"}, "query": {"Boyd"}}, func(u *User) bool { return u.Id == 0 }},
+	}
+
+	all := loadDataset(t)
+	for _, c := range cases {
+		c.params.Set("limit", "100")
+		c.params.Set("offset", "0")
+		w, users := search(t, srv, c.params, token)
+		if w.Code != http.StatusOK {
+			t.Errorf("%v: got status %d", c.params, w.Code)
+			continue
+		}
+
+		expected := 0
+		for i := range all {
+			if c.match(&all[i]) {
+				expected++
+			}
+		}
+		if len(users) != expected || expected == 0 {
+			t.Errorf("%v: got %d users, expected %d", c.params, len(users), expected)
+		}
+		for i := range users {
+			if !c.match(&users[i]) {
+				t.Errorf("%v: unexpected user %+v", c.params, users[i])
+			}
+		}
+	}
+}
+
+func TestSearchSort(t *testing.T) {
+	srv := New(loadDataset(t), token)
+
+	_, users := search(t, srv, url.Values{
+		"limit":       {"100"},
+		"offset":      {"0"},
+		"sort":        {"Age:desc,Name"},
+		"order_field": {"Id"},
+		"order_by":    {"-1"},
+	}, token)
+	if len(users) != 35 {
+		t.Fatalf("got %d users", len(users))
+	}
+	for i := 1; i < len(users); i++ {
+		a, b := users[i-1], users[i]
+		if a.Age < b.Age || a.Age == b.Age && a.Name > b.Name {
+			t.Errorf("users %d and %d are out of order", a.Id, b.Id)
+		}
+	}
+}
+
+func TestSearchFilterErrors(t *testing.T) {
+	srv := New(loadDataset(t), token)
+
+	cases := []struct {
+		params url.Values
+		error  string
+		field  string
+	}{
+		{url.Values{"min_age": {"x"}}, ErrorBadFilter, "min_age"},
+		{url.Values{"max_age": {"-1"}}, ErrorBadFilter, "max_age"},
+		{url.Values{"min_age": {"30"}, "max_age": {"20"}}, ErrorBadFilter, "max_age"},
+		{url.Values{"gender": {"other"}}, ErrorBadFilter, "gender"},
+		{url.Values{"ids": {"1,,2"}}, ErrorBadFilter, "ids"},
+		{url.Values{"sort": {"Age,About:desc"}}, ErrorBadOrderField, "About"},
+		{url.Values{"sort": {"Age:up"}}, ErrorBadOrderField, "Age"},
+		{url.Values{"sort": {"Age,"}}, ErrorBadOrderField, ""},
+	}
+
+	for _, c := range cases {
+		c.params.Set("limit", "1")
+		c.params.Set("offset", "0")
+		w, _ := search(t, srv, c.params, token)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v: got status %d", c.params, w.Code)
+			continue
+		}
+		resp := errorResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Error != c.error || resp.Field != c.field {
+			t.Errorf("%v: got %+v, expected %s %q", c.params, resp, c.error, c.field)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// OrderByRelevance is the order_field which ranks users by how well Name
+// and About match the words of query.
+const OrderByRelevance = "Relevance"
+
+// BM25 parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// words of the name count as if they were repeated in the text
+	nameWeight = 2
+)
+
+type posting struct {
+	doc  int
+	freq int
+}
+
+// textIndex is an inverted index over Name and About of users, ranked with
+// BM25.
+type textIndex struct {
+	postings  map[string][]posting
+	lengths   []int
+	avgLength float64
+}
+
+func newTextIndex(users []User) *textIndex {
+	idx := &textIndex{
+		postings: make(map[string][]posting),
+		lengths:  make([]int, len(users)),
+	}
+
+	total := 0
+	for doc, u := range users {
+		freqs := make(map[string]int)
+		for _, term := range tokenize(u.Name) {
+			freqs[term] += nameWeight
+			idx.lengths[doc] += nameWeight
+		}
+		for _, term := range tokenize(u.About) {
+			freqs[term]++
+			idx.lengths[doc]++
+		}
+		for term, freq := range freqs {
+			idx.postings[term] = append(idx.postings[term], posting{doc, freq})
+		}
+		total += idx.lengths[doc]
+	}
+	if len(users) > 0 {
+		idx.avgLength = float64(total) / float64(len(users))
+	}
+	return idx
+}
+
+// scores returns BM25 scores of the documents containing any term of query.
+func (idx *textIndex) scores(query string) map[int]float64 {
+	scores := make(map[int]float64)
+	n := float64(len(idx.lengths))
+	for _, term := range uniqueTerms(tokenize(query)) {
+		postings := idx.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for _, p := range postings {
+			tf := float64(p.freq)
+			norm := 1 - bm25B + bm25B*float64(idx.lengths[p.doc])/idx.avgLength
+			scores[p.doc] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+	return scores
+}
+
+// rank returns users matching query, the most relevant first, and their
+// scores by Id. An empty query matches every user, scores are nil then.
+func (s *SearchServer) rank(query string) ([]User, map[int]float64) {
+	if len(tokenize(query)) == 0 {
+		return append([]User(nil), s.users...), nil
+	}
+
+	scores := s.index.scores(query)
+	docs := make([]int, 0, len(scores))
+	for doc := range scores {
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		a, b := docs[i], docs[j]
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		return s.users[a].Id < s.users[b].Id
+	})
+
+	users := make([]User, len(docs))
+	byId := make(map[int]float64, len(docs))
+	for i, doc := range docs {
+		users[i] = s.users[doc]
+		byId[users[i].Id] = scores[doc]
+	}
+	return users, byId
+}
+
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = stem(word)
+	}
+	return words
+}
+
+var suffixes = []string{"ingly", "edly", "ing", "ies", "ed", "es", "ly", "s"}
+
+// stem strips a common English suffix, keeping at least three letters.
+func stem(word string) string {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(word, suffix) && len(word)-len(suffix) >= 3 {
+			if suffix == "ies" {
+				return word[:len(word)-3] + "y"
+			}
+			return word[:len(word)-len(suffix)]
+		}
+	}
+	return word
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	result := terms[:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			result = append(result, term)
+		}
+	}
+	return result
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	cases := map[string][]string{
+		"Boyd Wolf":                   {"boyd", "wolf"},
+		"Running, jumped; cities!":    {"runn", "jump", "city"},
+		"is as us":                    {"is", "as", "us"},
+		"e-mail: BOYDWOLF@hopeli.com": {"e", "mail", "boydwolf", "hopeli", "com"},
+		"  ":                          {},
+	}
+
+	for text, expected := range cases {
+		if got := tokenize(text); !reflect.DeepEqual(got, expected) && !(len(got) == 0 && len(expected) == 0) {
+			t.Errorf("%q: got %q, expected %q", text, got, expected)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	users := []User{
+		{Id: 0, Name: "Anna Lee", About: "likes cats and dogs"},
+		{Id: 1, Name: "Cat Stevens", About: "sings songs"},
+		{Id: 2, Name: "Bob Dog", About: "a dog person, dogs everywhere, walking dogs"},
+		{Id: 3, Name: "Eve Hill", About: "nothing to see"},
+	}
+	srv := New(users, token)
+
+	cases := map[string][]int{
+		"cat":       {1, 0},
+		"Dogs":      {2, 0},
+		"cats dogs": {0, 2, 1},
+		"zebra":     {},
+		"":          {0, 1, 2, 3},
+	}
+
+	for query, expected := range cases {
+		users, _ := srv.rank(query)
+		if got := ids(users); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%q: got %v, expected %v", query, got, expected)
+		}
+	}
+}
+
+func TestSearchRelevance(t *testing.T) {
+	srv := New(loadDataset(t), token)
+
+	w, users := search(t, srv, url.Values{
+		"limit":       {"3"},
+		"offset":      {"0"},
+		"query":       {"wolf boyd"},
+		"order_field": {OrderByRelevance},
+		"order_by":    {"1"},
+	}, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+	if len(users) != 1 || users[0].Name != "Boyd Wolf" {
+		t.Errorf("unexpected users: %v", ids(users))
+	}
+}
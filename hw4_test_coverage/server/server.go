@@ -0,0 +1,243 @@
+// Package server is the external search system which SearchClient talks to.
+// It searches users of dataset.xml kept in memory.
+package server
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	OrderByAsc  = -1
+	OrderByAsIs = 0
+	OrderByDesc = 1
+
+	ErrorBadOrderField = "ErrorBadOrderField"
+	ErrorBadOrderBy    = "ErrorBadOrderBy"
+	ErrorBadLimit      = "ErrorBadLimit"
+	ErrorBadOffset     = "ErrorBadOffset"
+	ErrorBadFilter     = "ErrorBadFilter"
+	ErrorBadCursor     = "ErrorBadCursor"
+
+	TokenHeader = "AccessToken"
+	// CursorHeader holds the cursor of the next page, it is absent on the
+	// last one
+	CursorHeader = "Next-Cursor"
+)
+
+type User struct {
+	Id     int
+	Name   string
+	Age    int
+	About  string
+	Gender string
+}
+
+type row struct {
+	Id        int    `xml:"id"`
+	FirstName string `xml:"first_name"`
+	LastName  string `xml:"last_name"`
+	Age       int    `xml:"age"`
+	About     string `xml:"about"`
+	Gender    string `xml:"gender"`
+}
+
+// Load reads users from a dataset in the format of dataset.xml.
+func Load(r io.Reader) ([]User, error) {
+	dataset := struct {
+		Rows []row `xml:"row"`
+	}{}
+	if err := xml.NewDecoder(r).Decode(&dataset); err != nil {
+		return nil, err
+	}
+
+	users := make([]User, 0, len(dataset.Rows))
+	for _, r := range dataset.Rows {
+		users = append(users, User{
+			Id:     r.Id,
+			Name:   r.FirstName + " " + r.LastName,
+			Age:    r.Age,
+			About:  r.About,
+			Gender: r.Gender,
+		})
+	}
+	return users, nil
+}
+
+func LoadFile(path string) ([]User, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Load(file)
+}
+
+// SearchServer answers requests of SearchClient: users whose Name or About
+// contains query, ordered by order_field, from offset, at most limit of them.
+// With order_field=Relevance query is a list of words instead, users having
+// any of them are ranked by BM25. Results can be filtered by age, gender and
+// ids, and sorted by several fields with sort, which overrides order_field.
+// With the cursor parameter pages are kept apart by cursors, not offsets.
+// Responses carry an ETag, a request with the same If-None-Match gets 304.
+type SearchServer struct {
+	users []User
+	index *textIndex
+	token string
+}
+
+// New creates a server over users, requests must carry token in the
+// AccessToken header. An empty token turns authorization off.
+func New(users []User, token string) *SearchServer {
+	return &SearchServer{users: users, index: newTextIndex(users), token: token}
+}
+
+type errorResponse struct {
+	Error string
+	// Field is the invalid parameter or sort field if known
+	Field string `json:",omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeFieldError(w, status, message, "")
+}
+
+func writeFieldError(w http.ResponseWriter, status int, message, field string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{message, field})
+}
+
+func (s *SearchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" && r.Header.Get(TokenHeader) != s.token {
+		http.Error(w, "401 - unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params := r.URL.Query()
+	limit, err := strconv.Atoi(params.Get("limit"))
+	if err != nil || limit <= 0 {
+		writeError(w, http.StatusBadRequest, ErrorBadLimit)
+		return
+	}
+	offset, err := strconv.Atoi(params.Get("offset"))
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, ErrorBadOffset)
+		return
+	}
+
+	orderField := params.Get("order_field")
+	less, ok := orderFields[orderField]
+	if !ok && orderField != OrderByRelevance {
+		writeError(w, http.StatusBadRequest, ErrorBadOrderField)
+		return
+	}
+	orderBy, err := strconv.Atoi(params.Get("order_by"))
+	if params.Get("order_by") == "" {
+		orderBy, err = OrderByAsIs, nil
+	}
+	if err != nil || orderBy < OrderByAsc || orderBy > OrderByDesc {
+		writeError(w, http.StatusBadRequest, ErrorBadOrderBy)
+		return
+	}
+	keys, invalid, ok := parseSort(params.Get("sort"))
+	if !ok {
+		writeFieldError(w, http.StatusBadRequest, ErrorBadOrderField, invalid)
+		return
+	}
+	f, invalid := parseFilter(params)
+	if invalid != "" {
+		writeFieldError(w, http.StatusBadRequest, ErrorBadFilter, invalid)
+		return
+	}
+	var after *cursor
+	search := searchHash(params)
+	if value := params.Get("cursor"); value != "" && value != CursorStart {
+		c, ok := decodeCursor(value)
+		if !ok || c.Search != search {
+			writeError(w, http.StatusBadRequest, ErrorBadCursor)
+			return
+		}
+		after = &c
+	}
+
+	var users []User
+	var scores map[int]float64
+	if orderField == OrderByRelevance {
+		// ranked already, order_by doesn't apply
+		users, scores = s.rank(params.Get("query"))
+	} else {
+		users = s.search(params.Get("query"))
+	}
+	users = f.apply(users)
+
+	var next string
+	if params.Get("cursor") != "" {
+		before := cursorOrder(keys, orderField == OrderByRelevance, less, orderBy)
+		users, next = pageAfter(users, scores, before, after, limit, search)
+		if next != "" {
+			w.Header().Set(CursorHeader, next)
+		}
+	} else {
+		users = pageAt(users, keys, less, orderBy, offset, limit)
+	}
+
+	body, _ := json.Marshal(users)
+	// the next cursor is a part of the response too
+	etag := fmt.Sprintf(`"%x"`, fnv64(append([]byte(next+"\n"), body...)))
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
+
+// pageAt sorts users and returns at most limit of them from offset.
+func pageAt(users []User, keys []sortKey, less func(a, b *User) bool, orderBy, offset, limit int) []User {
+	switch {
+	case len(keys) > 0:
+		sortBy(users, keys)
+	case less == nil:
+	case orderBy == OrderByAsc:
+		sort.SliceStable(users, func(i, j int) bool { return less(&users[i], &users[j]) })
+	case orderBy == OrderByDesc:
+		sort.SliceStable(users, func(i, j int) bool { return less(&users[j], &users[i]) })
+	}
+
+	if offset > len(users) {
+		offset = len(users)
+	}
+	users = users[offset:]
+	if limit < len(users) {
+		users = users[:limit]
+	}
+	return users
+}
+
+// orderFields compare users by the field, an empty field means Name.
+var orderFields = map[string]func(a, b *User) bool{
+	"":     func(a, b *User) bool { return a.Name < b.Name },
+	"Id":   func(a, b *User) bool { return a.Id < b.Id },
+	"Age":  func(a, b *User) bool { return a.Age < b.Age },
+	"Name": func(a, b *User) bool { return a.Name < b.Name },
+}
+
+func (s *SearchServer) search(query string) []User {
+	users := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		if query == "" || strings.Contains(u.Name, query) || strings.Contains(u.About, query) {
+			users = append(users, u)
+		}
+	}
+	return users
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const token = "token"
+
+func loadDataset(t *testing.T) []User {
+	users, err := LoadFile("../dataset.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+func search(t *testing.T, srv *SearchServer, params url.Values, header string) (*httptest.ResponseRecorder, []User) {
+	r := httptest.NewRequest("GET", "/?"+params.Encode(), nil)
+	r.Header.Set(TokenHeader, header)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+
+	users := []User{}
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w, users
+}
+
+func ids(users []User) []int {
+	result := make([]int, len(users))
+	for i, u := range users {
+		result[i] = u.Id
+	}
+	return result
+}
+
+func TestLoad(t *testing.T) {
+	users := loadDataset(t)
+	if len(users) != 35 {
+		t.Fatalf("got %d users, expected 35", len(users))
+	}
+	if u := users[0]; u.Id != 0 || u.Name != "Boyd Wolf" || u.Age != 22 || u.Gender != "male" || u.About == "" {
+		t.Errorf("unexpected first user: %+v", u)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	srv := New(loadDataset(t), token)
+
+	cases := []struct {
+		params url.Values
+		ids    []int
+	}{
+		{url.Values{"limit": {"3"}, "offset": {"0"}}, []int{0, 1, 2}},
+		{url.Values{"limit": {"3"}, "offset": {"33"}}, []int{33, 34}},
+		{url.Values{"limit": {"3"}, "offset": {"40"}}, []int{}},
+		{url.Values{"limit": {"3"}, "offset": {"0"}, "order_field": {"Id"}, "order_by": {"1"}}, []int{34, 33, 32}},
+		{url.Values{"limit": {"3"}, "offset": {"1"}, "order_field": {"Id"}, "order_by": {"-1"}}, []int{1, 2, 3}},
+		{url.Values{"limit": {"2"}, "offset": {"0"}, "order_field": {"Age"}, "order_by": {"-1"}}, []int{1, 15}},
+		{url.Values{"limit": {"2"}, "offset": {"0"}, "order_field": {""}, "order_by": {"-1"}}, []int{15, 16}},
+		{url.Values{"limit": {"5"}, "offset": {"0"}, "query": {"Boyd"}}, []int{0}},
+		{url.Values{"limit": {"50"}, "offset": {"0"}, "query": {"nulla"}, "order_field": {"Id"}, "order_by": {"-1"}}, nil},
+	}
+
+	for _, c := range cases {
+		w, users := search(t, srv, c.params, token)
+		if w.Code != http.StatusOK {
+			t.Errorf("%v: got status %d", c.params, w.Code)
+			continue
+		}
+		if c.ids == nil {
+			if len(users) == 0 {
+				t.Errorf("%v: expected users", c.params)
+			}
+			continue
+		}
+		if got := ids(users); len(got) != len(c.ids) || len(got) > 0 && got[0] != c.ids[0] || len(got) > 1 && got[len(got)-1] != c.ids[len(c.ids)-1] {
+			t.Errorf("%v: got %v, expected %v", c.params, got, c.ids)
+		}
+	}
+}
+
+func TestSearchOrder(t *testing.T) {
+	srv := New(loadDataset(t), token)
+
+	for field, less := range orderFields {
+		for _, orderBy := range []string{"-1", "1"} {
+			_, users := search(t, srv, url.Values{
+				"limit":       {"100"},
+				"offset":      {"0"},
+				"order_field": {field},
+				"order_by":    {orderBy},
+			}, token)
+			if len(users) != 35 {
+				t.Fatalf("%q %s: got %d users", field, orderBy, len(users))
+			}
+			for i := 1; i < len(users); i++ {
+				a, b := &users[i-1], &users[i]
+				if orderBy == "1" {
+					a, b = b, a
+				}
+				if less(b, a) {
+					t.Errorf("%q %s: users %d and %d are out of order", field, orderBy, a.Id, b.Id)
+				}
+			}
+		}
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	srv := New(loadDataset(t), token)
+
+	cases := []struct {
+		params url.Values
+		header string
+		status int
+		error  string
+	}{
+		{url.Values{"limit": {"1"}, "offset": {"0"}}, "bad token", http.StatusUnauthorized, ""},
+		{url.Values{"limit": {"x"}, "offset": {"0"}}, token, http.StatusBadRequest, ErrorBadLimit},
+		{url.Values{"limit": {"0"}, "offset": {"0"}}, token, http.StatusBadRequest, ErrorBadLimit},
+		{url.Values{"limit": {"1"}, "offset": {"-1"}}, token, http.StatusBadRequest, ErrorBadOffset},
+		{url.Values{"limit": {"1"}, "offset": {"0"}, "order_field": {"About"}}, token, http.StatusBadRequest, ErrorBadOrderField},
+		{url.Values{"limit": {"1"}, "offset": {"0"}, "order_by": {"2"}}, token, http.StatusBadRequest, ErrorBadOrderBy},
+	}
+
+	for _, c := range cases {
+		w, _ := search(t, srv, c.params, c.header)
+		if w.Code != c.status {
+			t.Errorf("%v: got status %d, expected %d", c.params, w.Code, c.status)
+			continue
+		}
+		if c.error == "" {
+			continue
+		}
+		resp := errorResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error != c.error {
+			t.Errorf("%v: got %q, expected error %s", c.params, w.Body, c.error)
+		}
+	}
+}
+
+func TestSearchETag(t *testing.T) {
+	srv := New(loadDataset(t), token)
+	params := url.Values{"limit": {"2"}, "offset": {"0"}}
+
+	w, _ := search(t, srv, params, token)
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected ETag")
+	}
+
+	r := httptest.NewRequest("GET", "/?"+params.Encode(), nil)
+	r.Header.Set(TokenHeader, token)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("got status %d, expected 304", w.Code)
+	}
+
+	params.Set("offset", "1")
+	w, _ = search(t, srv, params, token)
+	if w.Header().Get("ETag") == etag {
+		t.Errorf("other users have the same ETag %s", etag)
+	}
+}
+
+func TestSearchNoAuth(t *testing.T) {
+	srv := New(loadDataset(t), "")
+	for _, header := range []string{"", "any"} {
+		if w, _ := search(t, srv, url.Values{"limit": {"1"}, "offset": {"0"}}, header); w.Code != http.StatusOK {
+			t.Errorf("%q: got status %d", header, w.Code)
+		}
+	}
+}
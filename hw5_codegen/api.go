@@ -1,5 +1,7 @@
 package main
 
+//go:generate go run ./handlers_gen -output api_handlers.go
+
 import (
 	"context"
 	"fmt"
@@ -1,3 +1,5 @@
 module github.com/Willsem/golang-coursera/hw5_codegen
 
 go 1.16
+
+require golang.org/x/tools v0.1.12
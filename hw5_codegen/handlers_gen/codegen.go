@@ -3,15 +3,19 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"io/ioutil"
 	"log"
-	"os"
-	"regexp"
+	"path/filepath"
 	"strings"
 	"text/template"
+
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/imports"
 )
 
 var Empty struct{}
@@ -32,16 +36,21 @@ var (
 		"Render": Render,
 	}
 
-	generalTpl = template.Must(template.New("generalTpl").Funcs(tmplFuncs).Parse(`
+	generalTpl = template.Must(template.New("generalTpl").Funcs(tmplFuncs).Parse(`{{ .General.Header }}
+
 package {{.General.PackageName}}
 
 import (
 	"net/http"
 	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
 	"strconv"
+	"strings"
 	"runtime/debug"
 	"net/url"
+	"time"
 )
 
 type ApiErrorResponse struct {
@@ -92,6 +101,57 @@ func getOrDefault(values url.Values, key string, defaultValue string) string {
 	return items[0]
 }
 
+// lookup returns the value of key or the default, and false if there are
+// none of them.
+func lookup(values url.Values, key string, defaultValue string) (string, bool) {
+	if items := values[key]; len(items) > 0 {
+		return items[0], true
+	}
+	return defaultValue, defaultValue != ""
+}
+
+// lookupAll returns the values of a repeated key, the default is a list
+// separated by |.
+func lookupAll(values url.Values, key string, defaultValue string) ([]string, bool) {
+	if items := values[key]; len(items) > 0 {
+		return items, true
+	}
+	if defaultValue == "" {
+		return nil, false
+	}
+	return strings.Split(defaultValue, "|"), true
+}
+
+// isJSON reports whether params come in a JSON body rather than form values.
+func isJSON(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// decodeJSON reads a JSON object of params, values of nested objects get
+// dotted keys like form values of nested structs.
+func decodeJSON(body io.Reader) (map[string]json.RawMessage, error) {
+	values := map[string]json.RawMessage{}
+	if err := json.NewDecoder(body).Decode(&values); err != nil {
+		return nil, fmt.Errorf("invalid json body")
+	}
+	return flattenJSON(values), nil
+}
+
+func flattenJSON(values map[string]json.RawMessage) map[string]json.RawMessage {
+	flat := make(map[string]json.RawMessage, len(values))
+	for key, raw := range values {
+		flat[key] = raw
+		nested := map[string]json.RawMessage{}
+		if strings.HasPrefix(strings.TrimSpace(string(raw)), "{") && json.Unmarshal(raw, &nested) == nil {
+			for nestedKey, nestedRaw := range flattenJSON(nested) {
+				flat[key+"."+nestedKey] = nestedRaw
+			}
+		}
+	}
+	return flat
+}
+
 {{ range $key, $value := .ServeHTTP }}
 func (h *{{ $key }}) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer func() {
@@ -130,9 +190,17 @@ func (api *{{.Receiver}}) wrapper{{.Name}}(w http.ResponseWriter, r *http.Reques
 	}
 	{{ end }}
 
-	r.ParseForm()
 	params := new({{.In}})
-	err := params.fillFromForm(r.Form)
+	var err error
+	if isJSON(r) {
+		err = params.fillFromJSON(r.Body)
+	} else {
+		r.ParseForm()
+		err = params.fillFromForm(r.Form)
+	}
+	if err == nil {
+		err = params.validate()
+	}
 	if err != nil {
 		errorResponse(http.StatusBadRequest, err.Error(), w)
 		return
@@ -152,17 +220,35 @@ func (api *{{.Receiver}}) wrapper{{.Name}}(w http.ResponseWriter, r *http.Reques
 {{ range .ParamsStructures }}
 func (s *{{ .Name }}) fillFromForm(params url.Values) error {
 	{{ range .Fields }}
-		{{ if .IsString }}
-	s.{{ .FieldName }} = getOrDefault(params, "{{ .ParamName }}", "{{ .Default }}")
-		{{ end }}
-		{{ if .IsInt }}
-	{{ .FieldName }}, err := strconv.Atoi(getOrDefault(params, "{{ .ParamName }}", "{{ .Default }}"))
+	{{ template "formField" . }}
+	{{ end }}
+
+	return nil
+}
+
+// fillFromJSON takes params from a JSON object, missing ones and nulls of
+// non-pointer fields are treated as missing form values.
+func (s *{{ .Name }}) fillFromJSON(body io.Reader) error {
+	{{ if .Fields }}values{{ else }}_{{ end }}, err := decodeJSON(body)
 	if err != nil {
-		return fmt.Errorf("{{ .ParamName }} must be int")
+		return err
 	}
-	s.{{ .FieldName }} = {{ .FieldName }}
-		{{ end }}
+	{{ if .Fields }}params := url.Values{}{{ end }}
+	{{ range .Fields }}
+	if raw, ok := values["{{ .ParamName }}"]; ok{{ if not .Pointer }} && string(raw) != "null"{{ end }} {
+		if err := json.Unmarshal(raw, &s.{{ .FieldName }}); err != nil {
+			return fmt.Errorf("{{ .ParamName }} must be {{ .TypeName }}")
+		}
+	} else {
+		{{ template "formField" . }}
+	}
+	{{ end }}
+
+	return nil
+}
 
+func (s *{{ .Name }}) validate() error {
+	{{ range .Fields }}
 		{{ range .Validators }}
 		{{ Render .Template . }}
 		{{ end }}
@@ -171,35 +257,78 @@ func (s *{{ .Name }}) fillFromForm(params url.Values) error {
 	return nil
 }
 {{ end }}
+
+{{ define "formField" }}
+	{{ if .Slice }}
+	if items, ok := lookupAll(params, "{{ .ParamName }}", "{{ .Default }}"); ok {
+		s.{{ .FieldName }} = make({{ .ParamType }}, 0, len(items))
+		for _, item := range items {
+			{{ template "parseValue" . }}
+			s.{{ .FieldName }} = append(s.{{ .FieldName }}, value)
+		}
+	}
+	{{ else if .Pointer }}
+	if item, ok := lookup(params, "{{ .ParamName }}", "{{ .Default }}"); ok {
+		{{ template "parseValue" . }}
+		s.{{ .FieldName }} = &value
+	}
+	{{ else }}
+	{
+		item := getOrDefault(params, "{{ .ParamName }}", "{{ .Default }}")
+		{{ template "parseValue" . }}
+		s.{{ .FieldName }} = value
+	}
+	{{ end }}
+{{ end }}
+
+{{ define "parseValue" }}
+	{{ if eq .Kind "string" }}value := item
+	{{ else if eq .Kind "int" }}value, err := strconv.Atoi(item)
+	{{ else if eq .Kind "int64" }}value, err := strconv.ParseInt(item, 10, 64)
+	{{ else if eq .Kind "uint64" }}value, err := strconv.ParseUint(item, 10, 64)
+	{{ else if eq .Kind "float64" }}value, err := strconv.ParseFloat(item, 64)
+	{{ else if eq .Kind "bool" }}value, err := strconv.ParseBool(item)
+	{{ else if eq .Kind "time" }}value, err := time.Parse(time.RFC3339, item)
+	{{ end }}
+	{{ if ne .Kind "string" }}
+	if err != nil {
+		return fmt.Errorf("{{ .ParamName }} must be {{ .TypeName }}")
+	}
+	{{ end }}
+{{ end }}
 `))
 
 	validatorTmps = template.New("validatorTmpls")
 
 	enumValidatorTpl = template.Must(validatorTmps.New("enumValidatorTpl").Funcs(tmplFuncs).Parse(`
-	{{ .FieldName }}Values := map[string]struct{}{
-		{{ range $key, $value := .Values }}
-			"{{ $value }}"	: Empty,
+	{{ if .Guard }}if {{ .Guard }} {{ end }}{
+		values := map[{{ .Kind }}]struct{}{
+		{{ range .Literals }}
+			{{ . }}: Empty,
 		{{ end }}
-	}
-	if _, ok := {{ .FieldName }}Values[s.{{ .FieldName }}]; !ok {
-		return fmt.Errorf("{{ .ParamName }} must be one of [{{ Concat .Values }}]")
+		}
+		for _, item := range {{ .Items }} {
+			if _, ok := values[item]; !ok {
+				return fmt.Errorf("{{ .ParamName }} must be one of [{{ Concat .Values }}]")
+			}
+		}
 	}`))
 
 	requiredValidatorTpl = template.Must(validatorTmps.New("requiredValidatorTpl").Funcs(tmplFuncs).Parse(`
-	if s.{{ .FieldName }} == "" {
+	if {{ .Cond }} {
 		return fmt.Errorf("{{ .ParamName }} must me not empty")
 	}
 `))
 
 	minValidatorTpl = template.Must(validatorTmps.New("minValidatorTpl").Funcs(tmplFuncs).Parse(`
-	if {{if eq .ParamType "string"}}{{printf "len(s.%s)" .FieldName}}{{else}}{{printf "s.%s" .FieldName}}{{end}} < {{.Value}} {
-		return fmt.Errorf("{{.ParamName}}{{if eq .ParamType "string"}}{{" len"}}{{else}}{{end}} must be >= {{.Value}}")
+	if {{ .Cond }} {
+		return fmt.Errorf("{{ .ParamName }}{{ if .Len }} len{{ end }} must be >= {{ .Value }}")
 	}
 `))
 
 	maxValidatorTpl = template.Must(validatorTmps.New("maxValidatorTpl").Funcs(tmplFuncs).Parse(`
-	if {{if eq .ParamType "string"}}{{printf "len(s.%s)" .FieldName}}{{else}}{{printf "s.%s" .FieldName}}{{end}} > {{.Value}} {
-		return fmt.Errorf("{{.ParamName}}{{if eq .ParamType "string"}}{{" len"}}{{else}}{{end}} must be <= {{.Value}}")
+	if {{ .Cond }} {
+		return fmt.Errorf("{{ .ParamName }}{{ if .Len }} len{{ end }} must be <= {{ .Value }}")
 	}
 `))
 )
@@ -233,150 +362,43 @@ func (f *HttpFunction) IsValidateMethod() bool {
 	return f.Method != ""
 }
 
-type Validator interface{}
-
-type RequiredValidator struct {
-	FieldName string
-	ParamName string
-	Template  string
-}
-
-type MinValidator struct {
-	FieldName string
-	Value     string
-	ParamName string
-	ParamType string
-	Template  string
-}
-
-type MaxValidator struct {
-	FieldName string
-	Value     string
-	ParamName string
-	ParamType string
-	Template  string
-}
-
-type EnumValidator struct {
-	FieldName string
-	Values    []string
-	ParamName string
-	Template  string
-}
+// generatedHeader marks the output, such files are skipped when the
+// package is loaded again.
+const generatedHeader = "// Code generated by handlers_gen. DO NOT EDIT."
 
-type Field struct {
-	FieldName  string
-	ParamName  string
-	Default    string
-	ParamType  string
-	Validators []Validator
-}
+func main() {
+	dir := flag.String("dir", ".", "directory of the package with apigen:api methods")
+	output := flag.String("output", "api_handlers.go", "generated file, relative to -dir")
+	flag.Parse()
 
-var apiValidatorPattern = regexp.MustCompile(`".*"`)
-
-func (f *Field) ParseApiValidator(schema string) {
-	schema = strings.ReplaceAll(apiValidatorPattern.FindString(schema), "\"", "")
-	rules := strings.Split(schema, ",")
-	validators := make([]Validator, 0)
-	for _, rule := range rules {
-		switch {
-		case rule == "required":
-			validators = append(validators, RequiredValidator{
-				FieldName: f.FieldName,
-				ParamName: f.ParamName,
-				Template:  "requiredValidatorTpl",
-			})
-		case rule[:3] == "min":
-			validators = append(validators, MinValidator{
-				Value:     rule[4:],
-				FieldName: f.FieldName,
-				ParamName: f.ParamName,
-				ParamType: f.ParamType,
-				Template:  "minValidatorTpl",
-			})
-		case rule[:3] == "max":
-			validators = append(validators, MaxValidator{
-				Value:     rule[4:],
-				FieldName: f.FieldName,
-				ParamName: f.ParamName,
-				ParamType: f.ParamType,
-				Template:  "maxValidatorTpl",
-			})
-		case rule[:4] == "enum":
-			validators = append(validators, EnumValidator{
-				Values:    strings.Split(rule[5:], "|"),
-				FieldName: f.FieldName,
-				ParamName: f.ParamName,
-				Template:  "enumValidatorTpl",
-			})
-		case rule[:7] == "default":
-			f.Default = rule[8:]
-		case rule[:9] == "paramname":
-			f.ParamName = rule[10:]
-		}
+	if err := generate(*dir, *output); err != nil {
+		log.Fatal(err)
 	}
-	f.Validators = validators
-}
-
-func (f *Field) IsString() bool {
-	return f.ParamType == "string"
-}
-
-func (f *Field) IsInt() bool {
-	return f.ParamType == "int"
+	fmt.Println("Completed")
 }
 
-type ParamsStructure struct {
-	Name   string
-	Fields []Field
-}
+// generate writes handlers of the apigen:api methods of the package in dir
+// to output.
+func generate(dir, output string) error {
+	outPath := output
+	if !filepath.IsAbs(outPath) {
+		outPath = filepath.Join(dir, outPath)
+	}
 
-func main() {
 	fset := token.NewFileSet()
-	file, err := parser.ParseFile(fset, os.Args[1], nil, parser.ParseComments)
+	packageName, files, err := loadPackage(fset, dir, outPath)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	out, _ := os.Create(os.Args[2])
-
-	// Preprocess file
+	// Preprocess files
 	structures := map[string]ast.StructType{}
 	functions := []HttpFunction{}
-	for _, f := range file.Decls {
-		// processing structures
-		if g, ok := f.(*ast.GenDecl); ok {
-			for _, spec := range g.Specs {
-				if currType, ok := spec.(*ast.TypeSpec); ok {
-					if currStruct, ok := currType.Type.(*ast.StructType); ok {
-						structures[currType.Name.Name] = *currStruct
-					}
-				}
-			}
-		}
-
-		// processing functions
-		if fun, ok := f.(*ast.FuncDecl); ok {
-			if fun.Doc == nil || len(fun.Doc.List) == 0 {
-				continue
-			}
-			funcComment := fun.Doc.List[0].Text
-			if strings.HasPrefix(funcComment, "// apigen:api ") && fun.Recv != nil {
-				expr, _ := fun.Recv.List[0].Type.(*ast.StarExpr)
-				identReceiver, _ := expr.X.(*ast.Ident)
-				instructions := new(Instructions)
-				json.Unmarshal([]byte(strings.Replace(funcComment, "// apigen:api ", "", 1)), &instructions)
-				// fmt.Println(instructions)
-
-				identIn, _ := fun.Type.Params.List[1].Type.(*ast.Ident)
-				functions = append(functions, HttpFunction{
-					Receiver: identReceiver.Name,
-					In:       identIn.Name,
-					Path:     instructions.Url,
-					Auth:     instructions.Auth,
-					Method:   instructions.Method,
-					Name:     fun.Name.Name,
-				})
+	for _, file := range files {
+		for _, f := range file.Decls {
+			collectStructures(f, structures)
+			if fun, ok := apiFunction(f); ok {
+				functions = append(functions, fun)
 			}
 		}
 	}
@@ -395,17 +417,13 @@ func main() {
 		if _, ok := processedParams[fun.In]; ok {
 			continue
 		}
-		rawParam := structures[fun.In]
-		fields := make([]Field, 0)
-		for _, rawField := range rawParam.Fields.List {
-			t, _ := rawField.Type.(*ast.Ident)
-			field := Field{
-				FieldName: rawField.Names[0].Name,
-				ParamType: t.Name,
-				ParamName: strings.ToLower(rawField.Names[0].Name),
-			}
-			field.ParseApiValidator(rawField.Tag.Value)
-			fields = append(fields, field)
+		processedParams[fun.In] = struct{}{}
+		if _, ok := structures[fun.In]; !ok {
+			return fmt.Errorf("params struct %s of %s.%s not found in package %s", fun.In, fun.Receiver, fun.Name, packageName)
+		}
+		fields, err := paramsFields(fun.In, structures)
+		if err != nil {
+			return err
 		}
 
 		paramsStructures = append(paramsStructures, ParamsStructure{
@@ -414,18 +432,122 @@ func main() {
 		})
 	}
 
-	// fmt.Printf("type: %T data: %+v\n", paramsStructures, paramsStructures)
-
 	// Generate
-	generalTpl.Execute(out, TemplateVariables{
+	out := bytes.NewBuffer(nil)
+	err = generalTpl.Execute(out, TemplateVariables{
 		General: map[string]string{
 			"JSONErrorTag":    "`json:\"error\"`",
 			"JSONResponseTag": "`json:\"response\"`",
-			"PackageName":     file.Name.Name,
+			"PackageName":     packageName,
+			"Header":          generatedHeader,
 		},
 		ServeHTTP:        serveHttp,
 		ParamsStructures: paramsStructures,
 	})
+	if err != nil {
+		return err
+	}
+	// imports of the types which aren't used are dropped
+	src, err := imports.Process(outPath, out.Bytes(), nil)
+	if err != nil {
+		return fmt.Errorf("generated code is invalid: %w", err)
+	}
+	return ioutil.WriteFile(outPath, src, 0644)
+}
 
-	fmt.Println("Completed")
+// loadPackage parses all files of the package in dir except the generated
+// ones and the output.
+func loadPackage(fset *token.FileSet, dir, output string) (string, []*ast.File, error) {
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax,
+		Dir:  dir,
+		Fset: fset,
+		ParseFile: func(fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
+			return parser.ParseFile(fset, filename, src, parser.ParseComments)
+		},
+	}, ".")
+	if err != nil {
+		return "", nil, err
+	}
+	if len(pkgs) != 1 {
+		return "", nil, fmt.Errorf("expected one package in %s, got %d", dir, len(pkgs))
+	}
+	pkg := pkgs[0]
+	for _, e := range pkg.Errors {
+		if e.Kind != packages.TypeError {
+			return "", nil, e
+		}
+	}
+
+	output, err = filepath.Abs(output)
+	if err != nil {
+		return "", nil, err
+	}
+	files := make([]*ast.File, 0, len(pkg.Syntax))
+	for _, file := range pkg.Syntax {
+		path, err := filepath.Abs(fset.File(file.Pos()).Name())
+		if err != nil {
+			return "", nil, err
+		}
+		if path == output || isGenerated(file) {
+			continue
+		}
+		files = append(files, file)
+	}
+	return pkg.Name, files, nil
+}
+
+func isGenerated(file *ast.File) bool {
+	for _, group := range file.Comments {
+		if group.Pos() > file.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if comment.Text == generatedHeader {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func collectStructures(decl ast.Decl, structures map[string]ast.StructType) {
+	g, ok := decl.(*ast.GenDecl)
+	if !ok {
+		return
+	}
+	for _, spec := range g.Specs {
+		if currType, ok := spec.(*ast.TypeSpec); ok {
+			if currStruct, ok := currType.Type.(*ast.StructType); ok {
+				structures[currType.Name.Name] = *currStruct
+			}
+		}
+	}
+}
+
+// apiFunction reads a method marked with the apigen:api comment.
+func apiFunction(decl ast.Decl) (HttpFunction, bool) {
+	fun, ok := decl.(*ast.FuncDecl)
+	if !ok || fun.Doc == nil || len(fun.Doc.List) == 0 || fun.Recv == nil {
+		return HttpFunction{}, false
+	}
+	funcComment := fun.Doc.List[0].Text
+	if !strings.HasPrefix(funcComment, "// apigen:api ") {
+		return HttpFunction{}, false
+	}
+
+	expr, _ := fun.Recv.List[0].Type.(*ast.StarExpr)
+	identReceiver, _ := expr.X.(*ast.Ident)
+	instructions := new(Instructions)
+	json.Unmarshal([]byte(strings.Replace(funcComment, "// apigen:api ", "", 1)), &instructions)
+
+	identIn, _ := fun.Type.Params.List[1].Type.(*ast.Ident)
+	return HttpFunction{
+		Receiver: identReceiver.Name,
+		In:       identIn.Name,
+		Path:     instructions.Url,
+		Auth:     instructions.Auth,
+		Method:   instructions.Method,
+		Name:     fun.Name.Name,
+	}, true
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePackage creates a module with the files in a temporary directory.
+func writePackage(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	files["go.mod"] = "module example.com/api\n\ngo 1.16\n"
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestGenerateMultipleFiles(t *testing.T) {
+	dir := writePackage(t, map[string]string{
+		"api.go": `package api
+
+import "context"
+
+type Api struct{}
+
+// apigen:api {"url": "/user/create", "auth": true, "method": "POST"}
+func (a *Api) Create(ctx context.Context, in CreateParams) (*Api, error) {
+	return a, nil
+}
+`,
+		"params.go": `package api
+
+type CreateParams struct {
+	Login string ` + "`apivalidator:\"required,min=3\"`" + `
+	Age   int    ` + "`apivalidator:\"min=0,max=128\"`" + `
+}
+`,
+		// a stale output doesn't break the package
+		"api_handlers.go": "package api\n\nfunc (a *Api) wrapperCreate() {}\n",
+		"other_gen.go":    generatedHeader + "\n\npackage api\n\ntype CreateParams struct{}\n",
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := generate(dir, "api_handlers.go"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src, err := ioutil.ReadFile(filepath.Join(dir, "api_handlers.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "api_handlers.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("generated code doesn't parse: %v\n%s", err, src)
+	}
+	if file.Name.Name != "api" || !isGenerated(file) {
+		t.Errorf("unexpected package %s or missing header", file.Name.Name)
+	}
+	for _, expected := range []string{
+		"func (h *Api) ServeHTTP(",
+		"func (api *Api) wrapperCreate(",
+		"func (s *CreateParams) fillFromForm(",
+		"func (s *CreateParams) fillFromJSON(",
+		"func (s *CreateParams) validate() error {",
+		`"login len must be >= 3"`,
+		`"age must be <= 128"`,
+	} {
+		if !strings.Contains(string(src), expected) {
+			t.Errorf("expected %s in the generated code", expected)
+		}
+	}
+}
+
+func TestGenerateOtherGenerators(t *testing.T) {
+	dir := writePackage(t, map[string]string{
+		"api.go": `package api
+
+import "context"
+
+type Api struct{}
+
+// apigen:api {"url": "/user/create"}
+func (a *Api) Create(ctx context.Context, in CreateParams) (*Api, error) {
+	return a, nil
+}
+`,
+		// only the header of handlers_gen hides a file
+		"params_easyjson.go": "// Code generated by easyjson for marshaling/unmarshaling. DO NOT EDIT.\n\npackage api\n\ntype CreateParams struct {\n\tLogin string\n}\n",
+	})
+	if err := generate(dir, "api_handlers.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := ioutil.ReadFile(filepath.Join(dir, "api_handlers.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(src), "func (s *CreateParams) fillFromForm(") {
+		t.Errorf("expected handlers of CreateParams in the generated code")
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	dir := writePackage(t, map[string]string{
+		"api.go": `package api
+
+import "context"
+
+type Api struct{}
+
+// apigen:api {"url": "/user/create"}
+func (a *Api) Create(ctx context.Context, in MissingParams) (*Api, error) {
+	return a, nil
+}
+`,
+	})
+	err := generate(dir, "api_handlers.go")
+	if err == nil || !strings.Contains(err.Error(), "params struct MissingParams of Api.Create not found") {
+		t.Errorf("expected missing params error, got: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "api_handlers.go")); !os.IsNotExist(err) {
+		t.Errorf("nothing must be written on error")
+	}
+
+	dir = writePackage(t, map[string]string{"api.go": "package api\n\nfunc {\n"})
+	if err := generate(dir, "api_handlers.go"); err == nil {
+		t.Error("expected syntax error")
+	}
+}
+
+// TestGenerateTypes generates handlers of testdata/types and runs its tests.
+func TestGenerateTypes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go test")
+	}
+	files := map[string]string{}
+	for _, name := range []string{"api.go", "api_test.go"} {
+		src, err := ioutil.ReadFile(filepath.Join("testdata", "types", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = string(src)
+	}
+	dir := writePackage(t, files)
+	if err := generate(dir, "api_handlers.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("go", "test", ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("go test: %v\n%s", err, out)
+	}
+}
+
+func TestGenerateEmptyParams(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go build")
+	}
+	dir := writePackage(t, map[string]string{
+		"api.go": `package api
+
+import "context"
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct{}
+
+type Params struct{}
+
+// apigen:api {"url": "/ping"}
+func (a *Api) Ping(ctx context.Context, in Params) (*Api, error) {
+	return a, nil
+}
+`,
+	})
+	if err := generate(dir, "api_handlers.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("go", "build", ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("go build: %v\n%s", err, out)
+	}
+}
+
+func TestGenerateUnsupported(t *testing.T) {
+	cases := map[string]string{
+		"A map[string]int":                        "type map[string]int is not supported",
+		"A [2]int":                                "arrays are not supported",
+		"A *Inner":                                "type *Inner is not supported",
+		"A chan int":                              "type chan int is not supported",
+		"Inner":                                   "embedded field Inner of Params is not supported",
+		"A bool `apivalidator:\"required\"`":      "required doesn't apply to bool",
+		"A bool `apivalidator:\"min=1\"`":         "min and max don't apply to bool",
+		"A int `apivalidator:\"enum=1|x\"`":       "bad value x for int",
+		"A int `apivalidator:\"enum=1|01\"`":      "duplicate value 01 for int",
+		"A float64 `apivalidator:\"enum=1|1.0\"`": "duplicate value 1.0 for float64",
+		"A []string `apivalidator:\"enum=a|a\"`":  "duplicate value a for []string",
+		"A time.Time `apivalidator:\"enum=x\"`":   "enum doesn't apply to time",
+		"A time.Time `apivalidator:\"max=2020\"`": "time bound 2020 must be RFC3339",
+		"A string `apivalidator:\"min=x\"`":       "length bound x must be int",
+		"A int `apivalidator:\"unique\"`":         "unknown rule unique",
+		"A Inner `apivalidator:\"required\"`":     "only paramname applies to a nested struct",
+		"A Loop":                                  "struct Loop contains itself",
+	}
+	for field, expected := range cases {
+		dir := writePackage(t, map[string]string{
+			"api.go": `package api
+
+import (
+	"context"
+	"time"
+)
+
+var _ time.Time
+
+type Api struct{}
+
+type Inner struct{ B int }
+
+type Loop struct{ Next Loop2 }
+
+type Loop2 struct{ Back Loop }
+
+type Params struct {
+	` + field + `
+}
+
+// apigen:api {"url": "/"}
+func (a *Api) Do(ctx context.Context, in Params) (*Api, error) {
+	return a, nil
+}
+`,
+		})
+		if err := generate(dir, "api_handlers.go"); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected %q, got: %v", field, expected, err)
+		}
+	}
+}
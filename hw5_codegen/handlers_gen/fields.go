@@ -0,0 +1,358 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kinds of values a param may have, time is time.Time in RFC3339
+var kinds = map[string]bool{
+	"string":  true,
+	"int":     true,
+	"int64":   true,
+	"uint64":  true,
+	"float64": true,
+	"bool":    true,
+}
+
+const kindTime = "time"
+
+type Validator interface{}
+
+type RequiredValidator struct {
+	// Cond is true if the field is empty
+	Cond      string
+	ParamName string
+	Template  string
+}
+
+type MinValidator struct {
+	// Cond is true if the field or its length is less than Value
+	Cond      string
+	Value     string
+	Len       bool
+	ParamName string
+	Template  string
+}
+
+type MaxValidator struct {
+	Cond      string
+	Value     string
+	Len       bool
+	ParamName string
+	Template  string
+}
+
+type EnumValidator struct {
+	// Guard is the condition to check the field at all, empty if always
+	Guard     string
+	Kind      string
+	Items     string
+	Values    []string
+	Literals  []string
+	ParamName string
+	Template  string
+}
+
+type Field struct {
+	// FieldName is the path from the params struct, Filter.MinAge for
+	// fields of nested structs
+	FieldName string
+	ParamName string
+	Default   string
+	// ParamType is the Go type of the field like []int or *time.Time
+	ParamType string
+	// Kind is the type of a single value, see kinds
+	Kind       string
+	Slice      bool
+	Pointer    bool
+	Validators []Validator
+}
+
+// TypeName is the type in error messages.
+func (f *Field) TypeName() string {
+	name := f.Kind
+	if f.Kind == kindTime {
+		name = "RFC3339 time"
+	}
+	if f.Slice {
+		name = "list of " + name
+	}
+	return name
+}
+
+type ParamsStructure struct {
+	Name   string
+	Fields []Field
+}
+
+// paramsFields lists the fields of the params struct, fields of nested
+// structs go with their path and dotted param names.
+func paramsFields(name string, structures map[string]ast.StructType) ([]Field, error) {
+	return structFields(name, "", "", structures, map[string]bool{})
+}
+
+func structFields(name, pathPrefix, paramPrefix string, structures map[string]ast.StructType, parents map[string]bool) ([]Field, error) {
+	if parents[name] {
+		return nil, fmt.Errorf("struct %s contains itself", name)
+	}
+	parents[name] = true
+	defer delete(parents, name)
+
+	fields := make([]Field, 0)
+	for _, rawField := range structures[name].Fields.List {
+		if len(rawField.Names) == 0 {
+			return nil, fmt.Errorf("embedded field %s of %s is not supported", types.ExprString(rawField.Type), name)
+		}
+		tag := ""
+		if rawField.Tag != nil {
+			raw, _ := strconv.Unquote(rawField.Tag.Value)
+			tag = reflect.StructTag(raw).Get("apivalidator")
+		}
+
+		for _, ident := range rawField.Names {
+			field := Field{
+				FieldName: pathPrefix + ident.Name,
+				ParamName: strings.ToLower(ident.Name),
+				ParamType: types.ExprString(rawField.Type),
+			}
+			nested, err := field.parseType(rawField.Type, structures)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", name, ident.Name, err)
+			}
+			rules := field.parseApiValidator(tag)
+			field.ParamName = paramPrefix + field.ParamName
+
+			if nested != "" {
+				if len(rules) > 0 || field.Default != "" {
+					return nil, fmt.Errorf("%s.%s: only paramname applies to a nested struct", name, ident.Name)
+				}
+				nestedFields, err := structFields(nested, field.FieldName+".", field.ParamName+".", structures, parents)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, nestedFields...)
+				continue
+			}
+
+			if err := field.buildValidators(rules); err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", name, ident.Name, err)
+			}
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// parseType sets the kind of the field, or returns the name of the struct
+// for a nested one.
+func (f *Field) parseType(expr ast.Expr, structures map[string]ast.StructType) (string, error) {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		f.Pointer = true
+		expr = t.X
+	case *ast.ArrayType:
+		if t.Len != nil {
+			return "", fmt.Errorf("arrays are not supported, use a slice")
+		}
+		f.Slice = true
+		expr = t.Elt
+	}
+
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if kinds[t.Name] {
+			f.Kind = t.Name
+			return "", nil
+		}
+		if _, ok := structures[t.Name]; ok && !f.Pointer && !f.Slice {
+			return t.Name, nil
+		}
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok && pkg.Name == "time" && t.Sel.Name == "Time" {
+			f.Kind = kindTime
+			return "", nil
+		}
+	}
+	return "", fmt.Errorf("type %s is not supported", f.ParamType)
+}
+
+// parseApiValidator sets the param name and the default from the
+// apivalidator tag and returns the other rules.
+func (f *Field) parseApiValidator(tag string) []string {
+	rules := make([]string, 0)
+	for _, rule := range strings.Split(tag, ",") {
+		switch {
+		case rule == "":
+		case strings.HasPrefix(rule, "default="):
+			f.Default = rule[8:]
+		case strings.HasPrefix(rule, "paramname="):
+			f.ParamName = rule[10:]
+		default:
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (f *Field) buildValidators(rules []string) error {
+	// value is the field for checks of a present value
+	expr := "s." + f.FieldName
+	value, guard := expr, ""
+	if f.Pointer {
+		value, guard = "(*"+expr+")", expr+" != nil"
+	}
+
+	for _, rule := range rules {
+		switch {
+		case rule == "required":
+			cond, err := f.emptyCond(expr)
+			if err != nil {
+				return err
+			}
+			f.Validators = append(f.Validators, RequiredValidator{
+				Cond:      cond,
+				ParamName: f.ParamName,
+				Template:  "requiredValidatorTpl",
+			})
+		case strings.HasPrefix(rule, "min="), strings.HasPrefix(rule, "max="):
+			isMin := rule[:3] == "min"
+			cond, isLen, err := f.boundCond(value, rule[4:], isMin)
+			if err != nil {
+				return err
+			}
+			if guard != "" {
+				cond = guard + " && " + cond
+			}
+			if isMin {
+				f.Validators = append(f.Validators, MinValidator{
+					Cond:      cond,
+					Value:     rule[4:],
+					Len:       isLen,
+					ParamName: f.ParamName,
+					Template:  "minValidatorTpl",
+				})
+			} else {
+				f.Validators = append(f.Validators, MaxValidator{
+					Cond:      cond,
+					Value:     rule[4:],
+					Len:       isLen,
+					ParamName: f.ParamName,
+					Template:  "maxValidatorTpl",
+				})
+			}
+		case strings.HasPrefix(rule, "enum="):
+			values := strings.Split(rule[5:], "|")
+			literals, err := f.literals(values)
+			if err != nil {
+				return err
+			}
+			items := fmt.Sprintf("[]%s{%s}", f.Kind, value)
+			if f.Slice {
+				items = expr
+			}
+			f.Validators = append(f.Validators, EnumValidator{
+				Guard:     guard,
+				Kind:      f.Kind,
+				Items:     items,
+				Values:    values,
+				Literals:  literals,
+				ParamName: f.ParamName,
+				Template:  "enumValidatorTpl",
+			})
+		default:
+			return fmt.Errorf("unknown rule %s", rule)
+		}
+	}
+	return nil
+}
+
+// emptyCond is the condition of a missing value.
+func (f *Field) emptyCond(expr string) (string, error) {
+	switch {
+	case f.Pointer:
+		return expr + " == nil", nil
+	case f.Slice:
+		return "len(" + expr + ") == 0", nil
+	case f.Kind == "string":
+		return expr + ` == ""`, nil
+	case f.Kind == kindTime:
+		return expr + ".IsZero()", nil
+	case f.Kind == "bool":
+		return "", fmt.Errorf("required doesn't apply to bool, use *bool")
+	default:
+		return expr + " == 0", nil
+	}
+}
+
+// boundCond is the condition of a value out of the bound, strings and
+// slices are bound by their length.
+func (f *Field) boundCond(value, bound string, isMin bool) (cond string, isLen bool, err error) {
+	op := ">"
+	if isMin {
+		op = "<"
+	}
+
+	switch {
+	case f.Slice || f.Kind == "string":
+		if _, err := strconv.Atoi(bound); err != nil {
+			return "", false, fmt.Errorf("length bound %s must be int", bound)
+		}
+		return fmt.Sprintf("len(%s) %s %s", value, op, bound), true, nil
+	case f.Kind == kindTime:
+		t, err := time.Parse(time.RFC3339, bound)
+		if err != nil {
+			return "", false, fmt.Errorf("time bound %s must be RFC3339", bound)
+		}
+		method := "After"
+		if isMin {
+			method = "Before"
+		}
+		return fmt.Sprintf("%s.%s(time.Unix(%d, %d))", value, method, t.Unix(), t.Nanosecond()), false, nil
+	case f.Kind == "bool":
+		return "", false, fmt.Errorf("min and max don't apply to bool")
+	}
+
+	if _, err := f.literals([]string{bound}); err != nil {
+		return "", false, err
+	}
+	return fmt.Sprintf("%s %s %s", value, op, bound), false, nil
+}
+
+// literals checks values of the field kind and returns them as Go code,
+// values equal after parsing are duplicates.
+func (f *Field) literals(values []string) ([]string, error) {
+	literals := make([]string, len(values))
+	seen := make(map[interface{}]bool, len(values))
+	for i, v := range values {
+		var parsed interface{}
+		var err error
+		switch f.Kind {
+		case "string":
+			parsed = v
+			v = strconv.Quote(v)
+		case "int", "int64":
+			parsed, err = strconv.ParseInt(v, 10, 64)
+		case "uint64":
+			parsed, err = strconv.ParseUint(v, 10, 64)
+		case "float64":
+			parsed, err = strconv.ParseFloat(v, 64)
+		default:
+			err = fmt.Errorf("enum doesn't apply to %s", f.Kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad value %s for %s: %w", v, f.ParamType, err)
+		}
+		if seen[parsed] {
+			return nil, fmt.Errorf("duplicate value %s for %s", values[i], f.ParamType)
+		}
+		seen[parsed] = true
+		literals[i] = v
+	}
+	return literals, nil
+}
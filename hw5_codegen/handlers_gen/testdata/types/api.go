@@ -0,0 +1,41 @@
+package types
+
+import (
+	"context"
+	"time"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct{}
+
+type Filter struct {
+	MinAge *int     `apivalidator:"min=0,paramname=min_age" json:"min_age"`
+	Tags   []string `apivalidator:"enum=a|b|c" json:"tags"`
+}
+
+type SearchParams struct {
+	Query  string     `apivalidator:"required" json:"query"`
+	Active bool       `apivalidator:"default=true" json:"active"`
+	Score  float64    `apivalidator:"min=0.5,max=10,default=1" json:"score"`
+	Big    int64      `apivalidator:"default=0" json:"big"`
+	Count  uint64     `apivalidator:"default=1,max=100" json:"count"`
+	Since  time.Time  `apivalidator:"min=2020-01-01T00:00:00Z,default=2021-01-01T00:00:00Z" json:"since"`
+	Until  *time.Time `json:"until"`
+	Ids    []int      `apivalidator:"paramname=id,max=3" json:"ids"`
+	Codes  []int64    `apivalidator:"enum=1|2" json:"codes"`
+	Limit  *uint64    `apivalidator:"required,max=50" json:"limit"`
+	Filter Filter     `apivalidator:"paramname=f" json:"f"`
+}
+
+// apigen:api {"url": "/search"}
+func (a *Api) Search(ctx context.Context, in SearchParams) (*SearchParams, error) {
+	return &in, nil
+}
@@ -0,0 +1,103 @@
+package types
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type response struct {
+	Error    string        `json:"error"`
+	Response *SearchParams `json:"response"`
+}
+
+func search(t *testing.T, form, body string) response {
+	var r *http.Request
+	if body != "" {
+		r = httptest.NewRequest("POST", "/search", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+	} else {
+		r = httptest.NewRequest("GET", "/search?"+form, nil)
+	}
+	w := httptest.NewRecorder()
+	(&Api{}).ServeHTTP(w, r)
+
+	resp := response{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s%s: %v", form, body, err)
+	}
+	return resp
+}
+
+func TestForm(t *testing.T) {
+	resp := search(t, "query=q&limit=5&id=1&id=2&codes=2&f.min_age=18&f.tags=a&f.tags=c&until=2022-02-03T04:05:06Z&big=-9000000000", "")
+	p := resp.Response
+	if resp.Error != "" || p == nil {
+		t.Fatalf("unexpected error: %s", resp.Error)
+	}
+	if p.Query != "q" || !p.Active || p.Score != 1 || p.Count != 1 || p.Big != -9000000000 || *p.Limit != 5 ||
+		p.Since.Year() != 2021 || p.Until == nil || p.Until.Day() != 3 ||
+		len(p.Ids) != 2 || p.Ids[1] != 2 || len(p.Codes) != 1 ||
+		p.Filter.MinAge == nil || *p.Filter.MinAge != 18 || len(p.Filter.Tags) != 2 {
+		t.Errorf("unexpected params: %+v", p)
+	}
+
+	p = search(t, "query=q&limit=1&active=false", "").Response
+	if p == nil || p.Active || p.Until != nil || p.Ids != nil || p.Filter.MinAge != nil {
+		t.Errorf("unexpected params: %+v", p)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	resp := search(t, "", `{"query": "q", "limit": 5, "id": [1, 2], "f": {"min_age": 18, "tags": ["b"]}, "score": 2.5, "until": "2022-02-03T04:05:06Z"}`)
+	p := resp.Response
+	if resp.Error != "" || p == nil {
+		t.Fatalf("unexpected error: %s", resp.Error)
+	}
+	if p.Query != "q" || !p.Active || p.Score != 2.5 || *p.Limit != 5 || len(p.Ids) != 2 ||
+		*p.Filter.MinAge != 18 || p.Filter.Tags[0] != "b" || p.Until.Day() != 3 || p.Since.Year() != 2021 {
+		t.Errorf("unexpected params: %+v", p)
+	}
+
+	// nulls are missing values
+	p = search(t, "", `{"query": "q", "limit": 1, "active": null, "score": null, "since": null, "until": null, "codes": null, "f": {"min_age": null}}`).Response
+	if p == nil || !p.Active || p.Score != 1 || p.Since.Year() != 2021 || p.Until != nil || p.Codes != nil || p.Filter.MinAge != nil {
+		t.Errorf("unexpected params: %+v", p)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	cases := []struct {
+		form, body, error string
+	}{
+		{"limit=1", "", "query must me not empty"},
+		{"query=q", "", "limit must me not empty"},
+		{"query=q&limit=51", "", "limit must be <= 50"},
+		{"query=q&limit=-1", "", "limit must be uint64"},
+		{"query=q&limit=1&active=maybe", "", "active must be bool"},
+		{"query=q&limit=1&score=0.1", "", "score must be >= 0.5"},
+		{"query=q&limit=1&score=x", "", "score must be float64"},
+		{"query=q&limit=1&big=1.5", "", "big must be int64"},
+		{"query=q&limit=1&count=101", "", "count must be <= 100"},
+		{"query=q&limit=1&since=2019-12-31T23:59:59Z", "", "since must be >= 2020-01-01T00:00:00Z"},
+		{"query=q&limit=1&since=yesterday", "", "since must be RFC3339 time"},
+		{"query=q&limit=1&until=", "", "until must be RFC3339 time"},
+		{"query=q&limit=1&id=1&id=2&id=3&id=4", "", "id len must be <= 3"},
+		{"query=q&limit=1&id=1&id=x", "", "id must be list of int"},
+		{"query=q&limit=1&codes=3", "", "codes must be one of [1, 2]"},
+		{"query=q&limit=1&f.min_age=-1", "", "f.min_age must be >= 0"},
+		{"query=q&limit=1&f.tags=a&f.tags=d", "", "f.tags must be one of [a, b, c]"},
+		{"", `{"query": "q", "limit": 1, "f": {"tags": ["d"]}}`, "f.tags must be one of [a, b, c]"},
+		{"", `{"query": "q", "limit": 1, "f": {"min_age": "x"}}`, "f.min_age must be int"},
+		{"", `{"query": "q", "limit": 1, "id": 1}`, "id must be list of int"},
+		{"", `{"query": "q", "limit": 1, "since": "yesterday"}`, "since must be RFC3339 time"},
+		{"", `{"query": "q"}`, "limit must me not empty"},
+	}
+	for _, c := range cases {
+		if resp := search(t, c.form, c.body); resp.Error != c.error {
+			t.Errorf("%s%s: got %q, expected %q", c.form, c.body, resp.Error, c.error)
+		}
+	}
+}
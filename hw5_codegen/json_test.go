@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// JSONCase is sent both as a JSON body and as form values, the answers
+// must be the same.
+type JSONCase struct {
+	Path   string
+	Body   string
+	Form   string
+	Status int
+	Error  string
+}
+
+func post(t *testing.T, url, contentType, body string) (int, CR) {
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Auth", "100500")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	result := CR{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("cant unpack json %s: %v", data, err)
+	}
+	return resp.StatusCode, result
+}
+
+func TestJSONBody(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+	defer ts.Close()
+
+	cases := []JSONCase{
+		{ApiUserProfile, `{"login": "rvasily"}`, "login=rvasily", http.StatusOK, ""},
+		{ApiUserProfile, `{}`, "", http.StatusBadRequest, "login must me not empty"},
+		{ApiUserProfile, `{"login": "not_exist_user"}`, "login=not_exist_user", http.StatusNotFound, "user not exist"},
+		{ApiUserCreate, `{"login": "mr.json.user", "age": 32, "full_name": "Json User"}`, "", http.StatusOK, ""},
+		{ApiUserCreate, `{"login": "mr.json.user", "age": 32}`, "login=mr.json.user&age=32", http.StatusConflict, "user mr.json.user exist"},
+		{ApiUserCreate, `{"login": "short", "age": 32}`, "login=short&age=32", http.StatusBadRequest, "login len must be >= 10"},
+		{ApiUserCreate, `{"login": "mr.moderator", "age": 130}`, "login=mr.moderator&age=130", http.StatusBadRequest, "age must be <= 128"},
+		{ApiUserCreate, `{"login": "mr.moderator", "age": 32, "status": "root"}`, "login=mr.moderator&age=32&status=root", http.StatusBadRequest, "status must be one of [user, moderator, admin]"},
+		{ApiUserCreate, `{"login": "mr.moderator"}`, "login=mr.moderator", http.StatusBadRequest, "age must be int"},
+		{ApiUserCreate, `{"login": "mr.moderator", "age": null}`, "login=mr.moderator", http.StatusBadRequest, "age must be int"},
+		{ApiUserCreate, `{"login": "mr.moderator", "age": "32"}`, "login=mr.moderator&age=x", http.StatusBadRequest, "age must be int"},
+		{ApiUserCreate, `{"login": 42, "age": 32}`, "", http.StatusBadRequest, "login must be string"},
+		{ApiUserCreate, `[1, 2]`, "", http.StatusBadRequest, "invalid json body"},
+		{ApiUserCreate, `{"login": `, "", http.StatusBadRequest, "invalid json body"},
+	}
+
+	for _, c := range cases {
+		status, result := post(t, ts.URL+c.Path, "application/json; charset=utf-8", c.Body)
+		if status != c.Status || result["error"] != c.Error {
+			t.Errorf("%s %s: got %d %v, expected %d %q", c.Path, c.Body, status, result, c.Status, c.Error)
+		}
+		if c.Form == "" {
+			continue
+		}
+		status, result = post(t, ts.URL+c.Path, "application/x-www-form-urlencoded", c.Form)
+		if status != c.Status || result["error"] != c.Error {
+			t.Errorf("%s %s: got %d %v, expected %d %q", c.Path, c.Form, status, result, c.Status, c.Error)
+		}
+	}
+
+	status, result := post(t, ts.URL+ApiUserProfile, "application/json", `{"login": "mr.json.user"}`)
+	user, _ := result["response"].(map[string]interface{})
+	if status != http.StatusOK || user["full_name"] != "Json User" || user["status"] != float64(statusUser) {
+		t.Errorf("created user with defaults expected, got %d %v", status, result)
+	}
+}